@@ -10,39 +10,18 @@ param(
 if ($TargetEnv -eq "Prod") {
     Write-Host "🌍 Modo: PRODUCCIÓN (Render)" -ForegroundColor Cyan
     $BaseUrl = "https://verdustock-backend.onrender.com"
-    $EnvFileName = ".env.production"
 } else {
     Write-Host "💻 Modo: DESARROLLO (Localhost)" -ForegroundColor Yellow
     $BaseUrl = "http://localhost:10000" # Ajustado a tu puerto local
-    $EnvFileName = ".env.development"
 }
 
-# 2. Función para leer variables (ahora acepta el nombre del archivo)
-function Get-EnvValue {
-    param($Key, $File)
-    $envFilePath = "$PSScriptRoot\$File"
-    
-    if (-not (Test-Path $envFilePath)) {
-        Write-Error "El archivo '$File' no existe. Asegúrate de tenerlo creado localmente con la ADMIN_SECRET_KEY."
-        exit 1
-    }
-    
-    $val = Select-String -Path $envFilePath -Pattern "^$Key=(.+)$"
-    if ($val) {
-        return $val.Matches.Groups[1].Value.Trim()
-    }
-    return $null
-}
+# 2. Credenciales de un administrador (ADMIN_EMAILS en el servidor)
+#    Si el admin tiene 2FA activado, crear el usuario desde el panel web.
+$AdminEmail = Read-Host "Admin Email"
+$AdminPassword = Read-Host "Admin Password" -AsSecureString
+$AdminPasswordPlain = [System.Net.NetworkCredential]::new("", $AdminPassword).Password
 
-# 3. Obtener el secreto del archivo correspondiente
-$secret = Get-EnvValue "ADMIN_SECRET_KEY" $EnvFileName
-
-if (-not $secret) {
-    Write-Error "ADMIN_SECRET_KEY no encontrada en $EnvFileName"
-    exit 1
-}
-
-# 4. Pedir datos si faltan
+# 3. Pedir datos si faltan
 if (-not $Username) { $Username = Read-Host "Enter Username" }
 if (-not $Email) { $Email = Read-Host "Enter Email" }
 if (-not $Password) { $Password = Read-Host "Enter Password" }
@@ -53,13 +32,31 @@ $body = @{
     password = $Password
 } | ConvertTo-Json
 
-# 5. Ejecutar la petición
+# 4. Iniciar sesión como admin y ejecutar la petición
 try {
-    Write-Host "Conectando a: $BaseUrl/admin/create-user..." -ForegroundColor Gray
-    
-    $response = Invoke-RestMethod -Uri "$BaseUrl/admin/create-user" `
+    Write-Host "Iniciando sesión en: $BaseUrl/login..." -ForegroundColor Gray
+
+    $loginBody = @{ email = $AdminEmail; password = $AdminPasswordPlain } | ConvertTo-Json
+    $login = Invoke-RestMethod -Uri "$BaseUrl/login" `
+        -Method Post `
+        -ContentType "application/json" `
+        -Body $loginBody `
+        -SessionVariable adminSession
+
+    if ($login.mfaRequired) {
+        Write-Error "El admin tiene 2FA activado: creá el usuario desde el panel web."
+        exit 1
+    }
+
+    # Mandamos el access token en el header: con la cookie sola, el servidor exige un Origin del front (CSRF)
+    $accessToken = $adminSession.Cookies.GetCookies($BaseUrl)["token"].Value
+
+    Write-Host "Conectando a: $BaseUrl/admin/users..." -ForegroundColor Gray
+
+    $response = Invoke-RestMethod -Uri "$BaseUrl/admin/users" `
         -Method Post `
-        -Headers @{ "Content-Type" = "application/json"; "X-Admin-Secret" = $secret } `
+        -ContentType "application/json" `
+        -Headers @{ "Authorization" = "Bearer $accessToken" } `
         -Body $body
         
     Write-Host "¡Éxito! Usuario creado en $TargetEnv." -ForegroundColor Green
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EmailCollation compara emails sin distinguir mayúsculas. El índice único de users.email la
+// usa, así que las búsquedas por email tienen que pasarla para coincidir con él.
+var EmailCollation = &options.Collation{Locale: "en", Strength: 2}
+
+// EnsureIndexes crea los índices que necesitan las búsquedas por token y la limpieza automática.
+// Si falla solo se loguea: la app funciona igual, pero más lenta.
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		RefreshTokensCollection: {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "sessionId", Value: 1}}},
+			// TTL: Mongo borra los refresh tokens vencidos
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		PasswordResetsCollection: {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		LoginAttemptsCollection: {
+			{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+			// Los contadores sin fallos recientes se borran solos a la semana
+			{Keys: bson.D{{Key: "lastFailureAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(7 * 24 * 60 * 60)},
+		},
+		AuditCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "action", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		APIKeysCollection: {
+			{Keys: bson.D{{Key: "prefix", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "storeId", Value: 1}}},
+		},
+		StockMovementsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "productId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			// Reportes por tipo de movimiento (merma, ventas) en un período
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "type", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		PurchaseReceiptsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "supplierId", Value: 1}}},
+		},
+		SuppliersCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "name", Value: 1}}},
+		},
+		SupplierPricesCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "productId", Value: 1}, {Key: "supplierId", Value: 1}, {Key: "date", Value: -1}}},
+			{Keys: bson.D{{Key: "receiptId", Value: 1}}},
+		},
+		PriceChangesCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "productId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		BulkPriceOperationsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "createdAt", Value: -1}}},
+		},
+		StockAlertsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "status", Value: 1}, {Key: "updatedAt", Value: -1}}},
+			{Keys: bson.D{{Key: "productId", Value: 1}, {Key: "status", Value: 1}}},
+			// Una sola alerta activa por producto, aunque dos movimientos la abran a la vez
+			{Keys: bson.D{{Key: "productId", Value: 1}}, Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"active": true})},
+		},
+		InvitationsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "email", Value: 1}}},
+		},
+		SessionsCollection: {
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		},
+		UserCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}}},
+			// Un email, una cuenta: aunque dos registros lleguen a la vez o cambien las mayúsculas
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true).SetCollation(EmailCollation)},
+		},
+		StockCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}}},
+		},
+		SellsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "isClosed", Value: 1}, {Key: "date", Value: 1}}},
+		},
+		MPPaymentsCollection: {
+			{Keys: bson.D{{Key: "storeId", Value: 1}, {Key: "receivedAt", Value: -1}}},
+		},
+		StoresCollection: {
+			{Keys: bson.D{{Key: "mpAccount.userId", Value: 1}}},
+		},
+	}
+
+	for collection, models := range indexes {
+		if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+			log.Printf("⚠️ No se pudieron crear índices en %s: %v", collection.Name(), err)
+		}
+	}
+}
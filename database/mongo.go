@@ -15,6 +15,21 @@ var StockCollection *mongo.Collection
 var CatalogCollection *mongo.Collection
 var SellsCollection *mongo.Collection
 var MPPaymentsCollection *mongo.Collection
+var SessionsCollection *mongo.Collection
+var StoresCollection *mongo.Collection
+var RefreshTokensCollection *mongo.Collection
+var PasswordResetsCollection *mongo.Collection
+var LoginAttemptsCollection *mongo.Collection
+var AuditCollection *mongo.Collection
+var APIKeysCollection *mongo.Collection
+var InvitationsCollection *mongo.Collection
+var StockMovementsCollection *mongo.Collection
+var PurchaseReceiptsCollection *mongo.Collection
+var SuppliersCollection *mongo.Collection
+var SupplierPricesCollection *mongo.Collection
+var PriceChangesCollection *mongo.Collection
+var BulkPriceOperationsCollection *mongo.Collection
+var StockAlertsCollection *mongo.Collection
 
 func Connect(uri string, dbName string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -32,6 +47,43 @@ func Connect(uri string, dbName string) {
 	CatalogCollection = db.Collection("catalog")
 	SellsCollection = db.Collection("sells")
 	MPPaymentsCollection = db.Collection("mp_payments")
+	SessionsCollection = db.Collection("sessions")
+	StoresCollection = db.Collection("stores")
+	RefreshTokensCollection = db.Collection("refresh_tokens")
+	PasswordResetsCollection = db.Collection("password_resets")
+	LoginAttemptsCollection = db.Collection("login_attempts")
+	AuditCollection = db.Collection("audit_log")
+	APIKeysCollection = db.Collection("api_keys")
+	InvitationsCollection = db.Collection("invitations")
+	StockMovementsCollection = db.Collection("stock_movements")
+	PurchaseReceiptsCollection = db.Collection("purchase_receipts")
+	SuppliersCollection = db.Collection("suppliers")
+	SupplierPricesCollection = db.Collection("supplier_prices")
+	PriceChangesCollection = db.Collection("price_changes")
+	BulkPriceOperationsCollection = db.Collection("bulk_price_operations")
+	StockAlertsCollection = db.Collection("stock_alerts")
+
+	EnsureIndexes()
+}
+
+// WithTransaction runs fn inside a transaction, retried by the driver on transient errors.
+// Every operation in fn has to use the ctx it receives. If ctx already belongs to a
+// transaction, fn joins it and the outer caller commits.
+func WithTransaction(ctx context.Context, fn func(ctx mongo.SessionContext) error) error {
+	if session := mongo.SessionFromContext(ctx); session != nil {
+		return fn(mongo.NewSessionContext(ctx, session))
+	}
+
+	session, err := Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
 }
 
 func GetCollection(name string) *mongo.Collection {
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// EnsureAdmins marks as platform admins the users listed (by email) in ADMIN_EMAILS,
+// separated by commas, and removes the flag from everyone else. It is how the first admin
+// is bootstrapped.
+func EnsureAdmins() error {
+	var emails []string
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// La lista es la fuente de verdad: quien ya no está deja de ser admin
+	revoked, err := database.UserCollection.UpdateMany(ctx,
+		bson.M{"email": bson.M{"$nin": emails}, "isAdmin": true},
+		bson.M{"$unset": bson.M{"isAdmin": ""}},
+		options.Update().SetCollation(database.EmailCollation),
+	)
+	if err != nil {
+		return err
+	}
+	if revoked.ModifiedCount > 0 {
+		log.Printf("✅ %d usuarios dejaron de ser administradores", revoked.ModifiedCount)
+	}
+
+	if len(emails) == 0 {
+		return nil
+	}
+	result, err := database.UserCollection.UpdateMany(ctx,
+		bson.M{"email": bson.M{"$in": emails}, "isAdmin": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"isAdmin": true}},
+		options.Update().SetCollation(database.EmailCollation),
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("✅ %d usuarios marcados como administradores", result.ModifiedCount)
+	}
+	return nil
+}
+
+// paginationParams reads page/limit query params with sane defaults.
+func paginationParams(c *gin.Context) (int64, int64) {
+	page, err := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)), 10, 64)
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return page, limit
+}
+
+// adminUserView is what admins see of a user (never secrets).
+func adminUserView(user models.User) gin.H {
+	return gin.H{
+		"id":                    user.ID.Hex(),
+		"email":                 user.Email,
+		"username":              user.Username,
+		"role":                  user.GetRole(),
+		"storeId":               user.StoreID.Hex(),
+		"isAdmin":               user.IsAdmin,
+		"emailVerified":         user.EmailVerified,
+		"disabled":              user.Disabled,
+		"disabledAt":            user.DisabledAt,
+		"passwordResetRequired": user.PasswordResetRequired,
+		"mfaEnabled":            user.MFAEnabled,
+	}
+}
+
+// auditAdminAction records an admin action on a user, in the user's store so its owner can see it.
+func auditAdminAction(ctx context.Context, c *gin.Context, action string, target models.User) {
+	entry := requestAuditEntry(c, action)
+	entry.StoreID = &target.StoreID
+	entry.TargetType = "user"
+	entry.TargetID = target.ID.Hex()
+	entry.Details = map[string]interface{}{"email": target.Email}
+	recordAudit(ctx, c, entry)
+}
+
+// adminTargetUser parses :id and loads the user; on failure it writes the error response.
+func adminTargetUser(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var user models.User
+	userID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return user, false
+	}
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return user, false
+	}
+	return user, true
+}
+
+// AdminListUsersHandler lists users, optionally searching by email or username (?q=)
+func AdminListUsersHandler(c *gin.Context) {
+	page, limit := paginationParams(c)
+
+	filter := bson.M{}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(q), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": pattern},
+			bson.M{"username": pattern},
+		}
+	}
+	switch c.Query("status") {
+	case "disabled":
+		filter["disabled"] = true
+	case "active":
+		filter["disabled"] = bson.M{"$ne": true}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.UserCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar usuarios"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "email", Value: 1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.UserCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener usuarios"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar usuarios"})
+		return
+	}
+
+	items := []gin.H{}
+	for _, u := range users {
+		items = append(items, adminUserView(u))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": items,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// AdminGetUserHandler returns a user with its store and Mercado Pago link status
+func AdminGetUserHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := adminTargetUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	view := adminUserView(user)
+
+	var store models.Store
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": user.StoreID}).Decode(&store); err == nil {
+		mp := gin.H{"connected": store.MPAccountConnected}
+		if store.MPAccount != nil {
+			mp["mpUserId"] = store.MPAccount.UserID
+			mp["updatedAt"] = store.MPAccount.UpdatedAt
+			mp["expiresAt"] = store.MPAccount.UpdatedAt.Add(time.Duration(store.MPAccount.ExpiresIn) * time.Second)
+		}
+		view["store"] = gin.H{
+			"id":          store.ID.Hex(),
+			"name":        store.Name,
+			"isOwner":     store.OwnerID == user.ID,
+			"mercadoPago": mp,
+		}
+	}
+
+	activeSessions, _ := database.SessionsCollection.CountDocuments(ctx, bson.M{
+		"userId":    user.ID,
+		"revokedAt": nil,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	})
+	view["activeSessions"] = activeSessions
+
+	c.JSON(http.StatusOK, view)
+}
+
+// AdminDisableUserHandler disables an account and closes all its sessions
+func AdminDisableUserHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := adminTargetUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.ID.Hex() == c.GetString("userId") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No podés deshabilitar tu propia cuenta"})
+		return
+	}
+
+	_, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"disabled": true, "disabledAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al deshabilitar usuario"})
+		return
+	}
+
+	if _, err := revokeUserSessions(ctx, user.ID, nil, "account_disabled"); err != nil {
+		log.Printf("❌ Error revocando sesiones de %s: %v", user.ID.Hex(), err)
+	}
+
+	auditAdminAction(ctx, c, models.AuditUserDisabled, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Usuario deshabilitado"})
+}
+
+// AdminEnableUserHandler re-enables a disabled account
+func AdminEnableUserHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := adminTargetUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	_, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$unset": bson.M{"disabled": "", "disabledAt": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al habilitar usuario"})
+		return
+	}
+
+	auditAdminAction(ctx, c, models.AuditUserEnabled, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Usuario habilitado"})
+}
+
+// AdminForcePasswordResetHandler invalidates the current password, closes every session
+// and emails the user a reset link
+func AdminForcePasswordResetHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := adminTargetUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	// Reemplazamos el hash por uno de una contraseña aleatoria: la vieja deja de servir
+	random, err := middleware.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar contraseña temporal"})
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(random), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar contraseña"})
+		return
+	}
+
+	_, err = database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"password": string(hash), "passwordResetRequired": true}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar usuario"})
+		return
+	}
+
+	if _, err := revokeUserSessions(ctx, user.ID, nil, "password_reset_forced"); err != nil {
+		log.Printf("❌ Error revocando sesiones de %s: %v", user.ID.Hex(), err)
+	}
+
+	if err := sendPasswordResetEmail(ctx, user, c.ClientIP()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al enviar el email de reseteo"})
+		return
+	}
+
+	auditAdminAction(ctx, c, models.AuditPasswordReset, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Se envió un email para restablecer la contraseña"})
+}
+
+// AdminDeleteUserHandler deletes an account with its sessions and API keys.
+// An owner cannot be deleted while the store has other members; the sole owner is deleted
+// together with the store and its data (the audit log is kept).
+func AdminDeleteUserHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, ok := adminTargetUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.ID.Hex() == c.GetString("userId") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No podés borrar tu propia cuenta"})
+		return
+	}
+
+	var store models.Store
+	soleOwner := false
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": user.StoreID}).Decode(&store); err == nil && store.OwnerID == user.ID {
+		members, err := database.UserCollection.CountDocuments(ctx, bson.M{"storeId": store.ID, "_id": bson.M{"$ne": user.ID}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar los miembros de la tienda"})
+			return
+		}
+		if members > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Es dueño de una tienda con otros miembros: transferí la tienda o borrá los miembros primero"})
+			return
+		}
+		soleOwner = true
+	}
+
+	if _, err := revokeUserSessions(ctx, user.ID, nil, "account_deleted"); err != nil {
+		log.Printf("❌ Error revocando sesiones de %s: %v", user.ID.Hex(), err)
+	}
+	_, _ = database.APIKeysCollection.UpdateMany(ctx,
+		bson.M{"createdBy": user.ID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+
+	// El usuario y (si era el único dueño) su tienda se van juntos: no quedan tiendas sin dueño
+	err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		if _, err := database.UserCollection.DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+			return err
+		}
+		if !soleOwner {
+			return nil
+		}
+		return deleteStoreData(ctx, store.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al borrar usuario"})
+		return
+	}
+
+	auditAdminAction(ctx, c, models.AuditUserDeleted, user)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Usuario borrado", "storeDeleted": soleOwner})
+}
+
+// deleteStoreData deletes a store with everything scoped to it (including the Mercado Pago
+// link, which lives in the store). The audit log is kept.
+func deleteStoreData(ctx context.Context, storeID primitive.ObjectID) error {
+	collections := []*mongo.Collection{
+		database.StockCollection,
+		database.StockMovementsCollection,
+		database.StockAlertsCollection,
+		database.PriceChangesCollection,
+		database.BulkPriceOperationsCollection,
+		database.PurchaseReceiptsCollection,
+		database.SuppliersCollection,
+		database.SupplierPricesCollection,
+		database.SellsCollection,
+		database.MPPaymentsCollection,
+		database.APIKeysCollection,
+		database.InvitationsCollection,
+	}
+	for _, collection := range collections {
+		if _, err := collection.DeleteMany(ctx, bson.M{"storeId": storeID}); err != nil {
+			return err
+		}
+	}
+	_, err := database.StoresCollection.DeleteOne(ctx, bson.M{"_id": storeID})
+	return err
+}
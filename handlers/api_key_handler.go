@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListAPIKeysHandler returns the API keys of the store (never the key itself)
+func ListAPIKeysHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := database.APIKeysCollection.Find(ctx, bson.M{"storeId": storeID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener API keys"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var keys []models.APIKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar API keys"})
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKeyHandler creates a scoped API key. The full key is only returned in this response.
+func CreateAPIKeyHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("userId"))
+
+	var input struct {
+		Name      string              `json:"name" binding:"required"`
+		Scopes    []models.Permission `json:"scopes" binding:"required"`
+		ExpiresAt *time.Time          `json:"expiresAt"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Name) == "" || len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nombre y permisos son requeridos"})
+		return
+	}
+
+	role := middleware.CurrentRole(c)
+	scopes := []models.Permission{}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(models.APIKeyScopes, scope) || !role.Can(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Permiso no permitido: " + string(scope), "allowed": models.APIKeyScopes})
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La fecha de vencimiento ya pasó"})
+		return
+	}
+
+	key, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar API key"})
+		return
+	}
+
+	apiKey := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		StoreID:   storeID,
+		CreatedBy: userID,
+		Name:      strings.TrimSpace(input.Name),
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := database.APIKeysCollection.InsertOne(ctx, apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Guardá la clave ahora: no se vuelve a mostrar",
+		"key":     key,
+		"apiKey":  apiKey,
+	})
+}
+
+// RevokeAPIKeyHandler revokes an API key of the store
+func RevokeAPIKeyHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de API key inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.APIKeysCollection.UpdateOne(ctx,
+		bson.M{"_id": keyID, "storeId": storeID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al revocar API key"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key no encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revocada"})
+}
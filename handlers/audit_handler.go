@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordAudit appends an entry to the audit log, filling IP, user agent and date from the request.
+// A failure to audit never breaks the request: it is only logged.
+func recordAudit(ctx context.Context, c *gin.Context, entry models.AuditEntry) {
+	entry.ID = primitive.NewObjectID()
+	entry.IP = c.ClientIP()
+	entry.UserAgent = c.Request.UserAgent()
+	entry.CreatedAt = time.Now()
+
+	if _, err := database.AuditCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("❌ Error guardando auditoría %s: %v", entry.Action, err)
+	}
+}
+
+// userAuditEntry starts an entry whose actor is the given user, in the user's store.
+func userAuditEntry(user models.User, action string) models.AuditEntry {
+	storeID, userID := user.StoreID, user.ID
+	return models.AuditEntry{
+		StoreID:    &storeID,
+		ActorID:    &userID,
+		ActorEmail: user.Email,
+		Action:     action,
+	}
+}
+
+// requestAuditEntry starts an entry whose actor is who is operating the request
+// (the acting member on a shared terminal), in the current store.
+func requestAuditEntry(c *gin.Context, action string) models.AuditEntry {
+	entry := models.AuditEntry{Action: action}
+	if storeID, err := primitive.ObjectIDFromHex(c.GetString("storeId")); err == nil {
+		entry.StoreID = &storeID
+	}
+	if actorID, err := primitive.ObjectIDFromHex(middleware.ActingUserID(c)); err == nil {
+		entry.ActorID = &actorID
+	}
+	if middleware.AuthMethod(c) == middleware.AuthMethodAPIKey {
+		if apiKey, ok := middleware.CurrentAPIKey(c); ok {
+			entry.Details = map[string]interface{}{"apiKey": apiKey.Prefix}
+		}
+	}
+	return entry
+}
+
+// parseTimeParam accepts RFC3339 or a plain date (YYYY-MM-DD).
+func parseTimeParam(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// dateRangeFilter builds a Mongo range from the "from" and "to" query params (a plain
+// "to" date includes the whole day). It returns nil when neither is set and writes a
+// 400 when one is malformed.
+func dateRangeFilter(c *gin.Context) (bson.M, bool) {
+	dateRange := bson.M{}
+	if from := c.Query("from"); from != "" {
+		t, ok := parseTimeParam(from)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'from' inválida"})
+			return nil, false
+		}
+		dateRange["$gte"] = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, ok := parseTimeParam(to)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Fecha 'to' inválida"})
+			return nil, false
+		}
+		if len(to) == len("2006-01-02") {
+			t = t.Add(24 * time.Hour)
+		}
+		dateRange["$lt"] = t
+	}
+	if len(dateRange) == 0 {
+		return nil, true
+	}
+	return dateRange, true
+}
+
+// GetAuditLogHandler returns the audit entries of the store, newest first.
+// Filters: action, actorId, from, to, page, limit.
+func GetAuditLogHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	page, limit := paginationParams(c)
+
+	filter := bson.M{"storeId": storeID}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = action
+	}
+	if actor := c.Query("actorId"); actor != "" {
+		actorID, err := primitive.ObjectIDFromHex(actor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "actorId inválido"})
+			return
+		}
+		filter["actorId"] = actorID
+	}
+
+	createdAt, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if createdAt != nil {
+		filter["createdAt"] = createdAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.AuditCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar registros"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.AuditCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener auditoría"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar auditoría"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": entries,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
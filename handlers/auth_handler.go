@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 	"verdustock-auth/database"
 	"verdustock-auth/models"
@@ -11,10 +13,10 @@ import (
 	"verdustock-auth/middleware"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,92 +25,122 @@ func LoginHandler(c *gin.Context) {
 		Email      string `json:"email"`
 		Password   string `json:"password"`
 		RememberMe bool   `json:"rememberMe"`
+		Device     string `json:"device"`
 	}
 	if err := c.ShouldBindJSON(&creds); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
 		return
 	}
+	creds.Email = strings.TrimSpace(creds.Email)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var expiration time.Duration
-	if creds.RememberMe {
-		expiration = 30 * 24 * time.Hour
-	} else {
-		expiration = 24 * time.Hour
+	// Protección contra fuerza bruta: por cuenta y por IP
+	accountKey := accountAttemptKey(creds.Email)
+	if !checkLockout(ctx, c, accountKey, ipAttemptKey(c.ClientIP())) {
+		return
 	}
 
 	var user models.User
-	err := database.UserCollection.FindOne(ctx, bson.M{"email": creds.Email}).Decode(&user)
+	err := database.UserCollection.FindOne(ctx, bson.M{"email": creds.Email}, options.FindOne().SetCollation(database.EmailCollation)).Decode(&user)
 	if err != nil {
+		registerLoginFailure(ctx, c, creds.Email)
+		auditLoginFailure(ctx, c, creds.Email, nil, "unknown_account")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password)); err != nil {
+		registerLoginFailure(ctx, c, creds.Email)
+		auditLoginFailure(ctx, c, creds.Email, &user, "wrong_password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Credenciales inválidas"})
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userId": user.ID.Hex(),
-		"exp":    time.Now().Add(expiration).Unix(),
-	})
+	_ = resetAttempts(ctx, accountKey)
+
+	if user.Disabled {
+		auditLoginFailure(ctx, c, creds.Email, &user, "account_disabled")
+		c.JSON(http.StatusForbidden, gin.H{"error": "La cuenta está deshabilitada", "code": "ACCOUNT_DISABLED"})
+		return
+	}
+	if user.PasswordResetRequired {
+		auditLoginFailure(ctx, c, creds.Email, &user, "password_reset_required")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tenés que restablecer tu contraseña desde el email que te enviamos", "code": "PASSWORD_RESET_REQUIRED"})
+		return
+	}
+	if !user.EmailVerified {
+		auditLoginFailure(ctx, c, creds.Email, &user, "email_not_verified")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Tenés que confirmar tu email antes de iniciar sesión", "code": "EMAIL_NOT_VERIFIED"})
+		return
+	}
+
+	// Segundo paso: si tiene TOTP (o la tienda lo exige) todavía no hay sesión
+	if user.MFAEnabled || storeRequiresMFA(ctx, user.StoreID) {
+		mfaToken, err := middleware.IssueMFAToken(user.ID.Hex(), creds.RememberMe, creds.Device, !user.MFAEnabled)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar sesión"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfaRequired":        true,
+			"enrollmentRequired": !user.MFAEnabled,
+			"mfaToken":           mfaToken,
+		})
+		return
+	}
 
-	tokenString, _ := token.SignedString(middleware.GetSecret())
+	// Access token corto + refresh token rotativo (30 días si RememberMe)
+	if err := startSession(ctx, c, user, creds.RememberMe, creds.Device); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar sesión"})
+		return
+	}
+
+	entry := userAuditEntry(user, models.AuditLogin)
+	entry.Details = map[string]interface{}{"mfa": false, "rememberMe": creds.RememberMe}
+	recordAudit(ctx, c, entry)
 
-	middleware.SetAuthCookie(c, tokenString, expiration)
 	c.JSON(http.StatusOK, gin.H{"message": "Logueado correctamente"})
 }
 
+// auditLoginFailure records a rejected login. user is nil when the email is not registered.
+func auditLoginFailure(ctx context.Context, c *gin.Context, email string, user *models.User, reason string) {
+	entry := models.AuditEntry{ActorEmail: email, Action: models.AuditLoginFailed}
+	if user != nil {
+		entry = userAuditEntry(*user, models.AuditLoginFailed)
+	}
+	entry.Details = map[string]interface{}{"reason": reason}
+	recordAudit(ctx, c, entry)
+}
+
 func LogoutHandler(c *gin.Context) {
-	c.SetCookie(
-		"token",
-		"",
-		-1,
-		"/",
-		"",
-		false,
-		true,
-	)
+	// Revocamos la sesión del lado del servidor para que el refresh token no sirva más
+	if refreshToken := readRefreshToken(c); refreshToken != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var current models.RefreshToken
+		err := database.RefreshTokensCollection.FindOne(ctx, bson.M{"tokenHash": middleware.HashToken(refreshToken)}).Decode(&current)
+		if err == nil {
+			_ = revokeSession(ctx, current.SessionID, "logout")
+		}
+	}
+
+	middleware.ClearAuthCookies(c)
 	c.JSON(http.StatusOK, gin.H{"message": "Sesión cerrada correctamente"})
 }
 
 func AuthMeHandler(userCollection *mongo.Collection) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		tokenString, err := c.Cookie("token")
-		if err != nil || tokenString == "" {
+		userIDStr, exists := c.Get("userId")
+		if !exists {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autenticado"})
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return middleware.GetSecret(), nil
-		})
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido o expirado"})
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Claims inválidos"})
-			return
-		}
-
-		var userIDStr string
-		if v, ok := claims["userId"].(string); ok && v != "" {
-			userIDStr = v
-		} else if v, ok := claims["userID"].(string); ok && v != "" {
-			userIDStr = v
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "userId inválido"})
-			return
-		}
-
-		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de userId inválido"})
 			return
@@ -124,7 +156,11 @@ func AuthMeHandler(userCollection *mongo.Collection) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
+		// Mercado Pago ahora está vinculado a la tienda
+		var store models.Store
+		_ = database.StoresCollection.FindOne(ctx, bson.M{"_id": user.StoreID}).Decode(&store)
+
+		response := gin.H{
 			"status": "ok",
 			"user": gin.H{
 				"id":                 user.ID.Hex(),
@@ -132,32 +168,43 @@ func AuthMeHandler(userCollection *mongo.Collection) gin.HandlerFunc {
 				"username":           user.Username,
 				"theme":              user.Theme,
 				"language":           user.Language,
-				"mpAccountConnected": user.MPAccountConnected,
+				"role":               user.GetRole(),
+				"mfaEnabled":         user.MFAEnabled,
+				"storeId":            user.StoreID.Hex(),
+				"storeName":          store.Name,
+				"mpAccountConnected": store.MPAccountConnected,
 			},
-		})
-	}
-}
-
-func AdminCreateUserHandler(c *gin.Context) {
-	adminSecret := c.GetHeader("X-Admin-Secret")
-	expectedSecret := os.Getenv("ADMIN_SECRET_KEY")
+		}
 
-	if expectedSecret == "" {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Server misconfiguration: ADMIN_SECRET_KEY not set"})
-		return
-	}
+		// En una terminal compartida, el front muestra quién está cobrando
+		if middleware.IsSwitchedUser(c) {
+			var acting models.User
+			actingID, _ := primitive.ObjectIDFromHex(middleware.ActingUserID(c))
+			if err := userCollection.FindOne(ctx, bson.M{"_id": actingID}).Decode(&acting); err == nil {
+				response["actingUser"] = gin.H{
+					"id":       acting.ID.Hex(),
+					"username": acting.Username,
+					"role":     acting.GetRole(),
+				}
+			}
+		}
 
-	if adminSecret != expectedSecret {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Acceso denegado"})
-		return
+		c.JSON(http.StatusOK, response)
 	}
+}
 
+// AdminCreateUserHandler creates a user. Only reachable by platform admins (see middleware.RequireAdmin).
+func AdminCreateUserHandler(c *gin.Context) {
 	// ✅ SOLUCIÓN: Usamos una estructura auxiliar para recibir los datos
 	// Esto permite leer el "password" del JSON aunque el modelo User lo tenga oculto.
 	var input struct {
-		Username string `json:"username" binding:"required"`
-		Email    string `json:"email" binding:"required"`
-		Password string `json:"password" binding:"required"`
+		Username string      `json:"username" binding:"required"`
+		Email    string      `json:"email" binding:"required"`
+		Password string      `json:"password" binding:"required"`
+		Role     models.Role `json:"role"`
+		// Si viene storeId, el usuario se suma a esa tienda; si no, se le crea una propia
+		StoreID   string `json:"storeId"`
+		StoreName string `json:"storeName"`
 	}
 
 	// BindJSON ahora usa 'input' en vez de 'user'
@@ -169,41 +216,139 @@ func AdminCreateUserHandler(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// Check if user already exists
-	var existing models.User
-	err := database.UserCollection.FindOne(ctx, bson.M{"email": input.Email}).Decode(&existing)
-	if err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "El email ya está registrado"})
+	var storeID primitive.ObjectID
+	if input.StoreID != "" {
+		parsed, err := primitive.ObjectIDFromHex(input.StoreID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de tienda inválido"})
+			return
+		}
+		storeID = parsed
+		if count, _ := database.StoresCollection.CountDocuments(ctx, bson.M{"_id": storeID}); count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tienda no encontrada"})
+			return
+		}
+		if input.Role == "" {
+			input.Role = models.RoleCashier
+		}
+	} else {
+		// Sin tienda, el usuario nuevo es dueño de la suya
+		input.Role = models.RoleOwner
+	}
+	if !input.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rol inválido"})
+		return
+	}
+
+	if err := validatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Encrypt password (Usamos input.Password)
-	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	newUser, err := createUser(ctx, newUserParams{
+		Email:     input.Email,
+		Username:  input.Username,
+		Password:  input.Password,
+		Role:      input.Role,
+		StoreID:   storeID,
+		StoreName: input.StoreName,
+	})
+	if errors.Is(err, errEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "El email ya está registrado"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar contraseña"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar usuario"})
 		return
 	}
 
+	// La cuenta queda sin verificar hasta que el usuario confirme su email
+	sendVerificationEmail(newUser)
+
+	entry := requestAuditEntry(c, models.AuditUserCreated)
+	entry.StoreID = &newUser.StoreID
+	entry.TargetType = "user"
+	entry.TargetID = newUser.ID.Hex()
+	entry.Details = map[string]interface{}{"email": newUser.Email, "role": newUser.Role, "newStore": storeID.IsZero()}
+	recordAudit(ctx, c, entry)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Usuario creado exitosamente",
+		"userId":  newUser.ID,
+		"storeId": newUser.StoreID,
+	})
+}
+
+var errEmailTaken = errors.New("el email ya está registrado")
+
+// newUserParams are the fields needed to create an account.
+type newUserParams struct {
+	Email         string
+	Username      string
+	Password      string
+	Role          models.Role
+	StoreID       primitive.ObjectID // Si está vacío, se crea una tienda propia y el usuario es el dueño
+	StoreName     string
+	EmailVerified bool
+}
+
+// createUser hashes the password and inserts the user (and its store when it has none).
+// The password policy is checked by the caller.
+func createUser(ctx context.Context, p newUserParams) (models.User, error) {
+	email := strings.TrimSpace(p.Email)
+
+	// Check if user already exists
+	count, err := database.UserCollection.CountDocuments(ctx, bson.M{"email": email}, options.Count().SetCollation(database.EmailCollation))
+	if err != nil {
+		return models.User{}, err
+	}
+	if count > 0 {
+		return models.User{}, errEmailTaken
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(p.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+
 	// Creamos el modelo User real manualmente
 	newUser := models.User{
 		ID:       primitive.NewObjectID(),
-		Email:    input.Email,
-		Username: input.Username,
+		Email:    email,
+		Username: p.Username,
 		Password: string(hash), // Guardamos el hash
 		Theme:    "light",      // Valores por defecto
 		Language: "es",
-		// MPAccount queda vacío/nil por defecto
+		Role:     p.Role,
+		StoreID:  p.StoreID,
+
+		EmailVerified: p.EmailVerified,
+	}
+	if p.EmailVerified {
+		now := time.Now()
+		newUser.EmailVerifiedAt = &now
 	}
 
-	// Save to database
-	res, err := database.UserCollection.InsertOne(ctx, newUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar usuario"})
-		return
+	newStore := newUser.StoreID.IsZero()
+	if newStore {
+		store, err := createStoreForUser(ctx, newUser, p.StoreName)
+		if err != nil {
+			return models.User{}, err
+		}
+		newUser.StoreID = store.ID
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Usuario creado exitosamente",
-		"userId":  res.InsertedID,
-	})
+	if _, err := database.UserCollection.InsertOne(ctx, newUser); err != nil {
+		// Sin el usuario la tienda quedaría huérfana
+		if newStore {
+			if _, delErr := database.StoresCollection.DeleteOne(ctx, bson.M{"_id": newUser.StoreID}); delErr != nil {
+				log.Printf("❌ Error borrando la tienda huérfana %s: %v", newUser.StoreID.Hex(), delErr)
+			}
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return models.User{}, errEmailTaken
+		}
+		return models.User{}, err
+	}
+	return newUser, nil
 }
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	bulkModePercent = "percent"
+	bulkModeFixed   = "fixed"
+
+	bulkScopeAll      = "all"
+	bulkScopeType     = "type"
+	bulkScopeProducts = "products"
+)
+
+// Redondeos permitidos para los precios nuevos (0 = al centavo)
+var bulkRoundings = map[float64]bool{0: true, 1: true, 5: true, 10: true, 50: true, 100: true}
+
+var productTypes = map[models.ProductType]bool{
+	models.Fruit:     true,
+	models.Vegetable: true,
+	models.Ortaliza:  true,
+	models.Other:     true,
+}
+
+// priceField is the product field that holds the price of that kind.
+func priceField(kind models.PriceKind, list string) string {
+	switch kind {
+	case models.PriceKindCost:
+		return "costPrice"
+	case models.PriceKindList:
+		return "listPrices." + list
+	default:
+		return "salePrice"
+	}
+}
+
+// currentPrice reads the price of that kind from the product.
+func currentPrice(product models.Product, kind models.PriceKind, list string) float64 {
+	switch kind {
+	case models.PriceKindCost:
+		return product.CostPrice
+	case models.PriceKindList:
+		return product.ListPrices[list]
+	default:
+		return product.SalePrice
+	}
+}
+
+// bulkNewPrice applies the increase and the rounding. The result is never negative, and a
+// positive price never rounds below one rounding step (it would leave the product for free).
+func bulkNewPrice(old float64, mode string, value, rounding float64) float64 {
+	price := old + value
+	if mode == bulkModePercent {
+		price = old * (1 + value/100)
+	}
+	// Primero al centavo: 1000 * 1.235 da 1234.999... y redondeado a 10 tiene que dar 1240
+	price = roundMoney(price)
+	if rounding > 0 && price > 0 {
+		price = math.Max(math.Round(price/rounding)*rounding, rounding)
+	}
+	return math.Max(price, 0)
+}
+
+// BulkPriceUpdateHandler raises (or lowers) a price of many products at once, by percentage
+// or fixed amount, for all products, one ProductType or a chosen set. With dryRun it only
+// returns the preview. Products without that price (0) are left alone.
+func BulkPriceUpdateHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Mode       string             `json:"mode" binding:"required"`
+		Value      float64            `json:"value"`
+		Kind       models.PriceKind   `json:"kind"` // sale (por defecto), cost o list
+		PriceList  string             `json:"priceList"`
+		Scope      string             `json:"scope" binding:"required"`
+		Type       models.ProductType `json:"type"`
+		ProductIDs []string           `json:"productIds"`
+		Rounding   float64            `json:"rounding"`
+		DryRun     bool               `json:"dryRun"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	switch {
+	case input.Mode != bulkModePercent && input.Mode != bulkModeFixed:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El modo debe ser percent o fixed"})
+		return
+	case input.Value == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El valor no puede ser cero"})
+		return
+	case input.Mode == bulkModePercent && input.Value <= -100:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El porcentaje debe ser mayor a -100"})
+		return
+	case !bulkRoundings[input.Rounding]:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Redondeo inválido (0, 1, 5, 10, 50 o 100)"})
+		return
+	}
+	if input.Kind == "" {
+		input.Kind = models.PriceKindSale
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	switch input.Kind {
+	case models.PriceKindSale, models.PriceKindCost:
+		input.PriceList = ""
+	case models.PriceKindList:
+		codes, err := storePriceLists(ctx, storeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener listas de precios"})
+			return
+		}
+		if !codes[input.PriceList] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La lista de precios no existe"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de precio inválido"})
+		return
+	}
+
+	filter := bson.M{"storeId": storeID, "archivedAt": nil}
+	switch input.Scope {
+	case bulkScopeAll:
+	case bulkScopeType:
+		if !productTypes[input.Type] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de producto inválido"})
+			return
+		}
+		filter["type"] = input.Type
+	case bulkScopeProducts:
+		if len(input.ProductIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Elegí al menos un producto"})
+			return
+		}
+		ids := make([]primitive.ObjectID, 0, len(input.ProductIDs))
+		for _, hex := range input.ProductIDs {
+			id, err := primitive.ObjectIDFromHex(hex)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+				return
+			}
+			ids = append(ids, id)
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El alcance debe ser all, type o products"})
+		return
+	}
+
+	cursor, err := database.StockCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos"})
+		return
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar productos"})
+		return
+	}
+
+	items := []models.BulkPriceItem{}
+	for _, product := range products {
+		old := currentPrice(product, input.Kind, input.PriceList)
+		if old == 0 {
+			continue
+		}
+		newPrice := bulkNewPrice(old, input.Mode, input.Value, input.Rounding)
+		if newPrice == old {
+			continue
+		}
+		items = append(items, models.BulkPriceItem{
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			OldPrice:    old,
+			NewPrice:    newPrice,
+		})
+	}
+
+	if input.DryRun {
+		c.JSON(http.StatusOK, gin.H{"dryRun": true, "count": len(items), "items": items})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ningún precio cambia con esos datos"})
+		return
+	}
+
+	operation := models.BulkPriceOperation{
+		ID:          primitive.NewObjectID(),
+		StoreID:     storeID,
+		Kind:        input.Kind,
+		PriceList:   input.PriceList,
+		Mode:        input.Mode,
+		Value:       input.Value,
+		Rounding:    input.Rounding,
+		Scope:       input.Scope,
+		ProductType: input.Type,
+		UserID:      actingUserFromContext(c),
+		CreatedAt:   time.Now(),
+	}
+
+	// Cada producto se actualiza solo si su precio sigue siendo el de la vista previa
+	field := priceField(input.Kind, input.PriceList)
+	applied := make([]models.BulkPriceItem, 0, len(items))
+	for _, item := range items {
+		result, err := database.StockCollection.UpdateOne(ctx,
+			bson.M{"_id": item.ProductID, "storeId": storeID, "archivedAt": nil, field: item.OldPrice},
+			bson.M{"$set": bson.M{field: item.NewPrice}},
+		)
+		if err != nil || result.MatchedCount == 0 {
+			continue
+		}
+		applied = append(applied, item)
+	}
+	operation.Items = applied
+
+	if _, err := database.BulkPriceOperationsCollection.InsertOne(ctx, operation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Los precios se actualizaron pero no se pudo guardar la operación para deshacerla"})
+		return
+	}
+	recordPriceChanges(ctx, bulkPriceChanges(operation, false), operation.UserID, models.PriceSourceBulk, &operation.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"operation": operation,
+		"count":     len(applied),
+		"skipped":   len(items) - len(applied),
+	})
+}
+
+// bulkPriceChanges turns the items of an operation into price log entries (reversed when undoing).
+func bulkPriceChanges(operation models.BulkPriceOperation, undo bool) []models.PriceChange {
+	changes := make([]models.PriceChange, 0, len(operation.Items))
+	for _, item := range operation.Items {
+		oldPrice, newPrice := item.OldPrice, item.NewPrice
+		if undo {
+			oldPrice, newPrice = newPrice, oldPrice
+		}
+		changes = append(changes, models.PriceChange{
+			StoreID:     operation.StoreID,
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Kind:        operation.Kind,
+			PriceList:   operation.PriceList,
+			OldPrice:    oldPrice,
+			NewPrice:    newPrice,
+		})
+	}
+	return changes
+}
+
+// UndoBulkPriceUpdateHandler reverts the last bulk operation of the store. Products whose
+// price was changed again afterwards are left as they are and reported as skipped.
+func UndoBulkPriceUpdateHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var last models.BulkPriceOperation
+	err := database.BulkPriceOperationsCollection.FindOne(ctx,
+		bson.M{"storeId": storeID},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No hay actualizaciones masivas para deshacer"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener la última actualización"})
+		return
+	}
+
+	userID := actingUserFromContext(c)
+	now := time.Now()
+
+	// Marcamos la operación como deshecha de forma atómica: no se puede deshacer dos veces
+	result, err := database.BulkPriceOperationsCollection.UpdateOne(ctx,
+		bson.M{"_id": last.ID, "undoneAt": nil},
+		bson.M{"$set": bson.M{"undoneAt": now, "undoneBy": userID}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al deshacer la actualización"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "La última actualización masiva ya fue deshecha"})
+		return
+	}
+
+	field := priceField(last.Kind, last.PriceList)
+	restored := last
+	restored.Items = nil
+	skipped := []string{}
+	for _, item := range last.Items {
+		result, err := database.StockCollection.UpdateOne(ctx,
+			bson.M{"_id": item.ProductID, "storeId": storeID, "archivedAt": nil, field: item.NewPrice},
+			bson.M{"$set": bson.M{field: item.OldPrice}},
+		)
+		if err != nil || result.MatchedCount == 0 {
+			skipped = append(skipped, item.ProductName)
+			continue
+		}
+		restored.Items = append(restored.Items, item)
+	}
+	recordPriceChanges(ctx, bulkPriceChanges(restored, true), userID, models.PriceSourceBulk, &last.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("Se restauraron %d precios", len(restored.Items)),
+		"restored": len(restored.Items),
+		"skipped":  skipped,
+	})
+}
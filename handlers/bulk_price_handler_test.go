@@ -0,0 +1,32 @@
+package handlers
+
+import "testing"
+
+func TestBulkNewPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      float64
+		mode     string
+		value    float64
+		rounding float64
+		want     float64
+	}{
+		{"percent to the cent", 1000, bulkModePercent, 12.5, 0, 1125},
+		{"percent keeps cents", 99.99, bulkModePercent, 10, 0, 109.99},
+		{"fixed", 1000, bulkModeFixed, 150, 0, 1150},
+		{"round to 10 down", 1234, bulkModeFixed, 0.4, 10, 1230},
+		{"round to 10 up", 1000, bulkModePercent, 23.5, 10, 1240},
+		{"round to 50 down", 1000, bulkModePercent, 12, 50, 1100},
+		{"round to 50 up", 1000, bulkModePercent, 13, 50, 1150},
+		{"fixed decrease clamps at 0", 100, bulkModeFixed, -150, 0, 0},
+		{"small price rounds up to one step", 20, bulkModePercent, 10, 50, 50},
+		{"big decrease keeps one step", 20, bulkModePercent, -90, 50, 50},
+		{"rounding to 10 of a small price", 4, bulkModeFixed, 0, 10, 10},
+		{"decrease to 0 with rounding", 100, bulkModeFixed, -150, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := bulkNewPrice(tt.old, tt.mode, tt.value, tt.rounding); got != tt.want {
+			t.Errorf("%s: bulkNewPrice(%v, %s, %v, %v) = %v, want %v", tt.name, tt.old, tt.mode, tt.value, tt.rounding, got, tt.want)
+		}
+	}
+}
@@ -10,7 +10,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -23,8 +22,10 @@ type PendingBox struct {
 }
 
 func CheckPendingBoxesHandler(c *gin.Context) {
-	userIDStr, _ := c.Get("userId")
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
 
 	// 1. Calcular el inicio del día de HOY (00:00:00)
 	loc := time.FixedZone("ART", -3*60*60) // Ajusta a tu zona horaria
@@ -35,10 +36,10 @@ func CheckPendingBoxesHandler(c *gin.Context) {
 	defer cancel()
 
 	// 2. Pipeline de Agregación
-	// Buscamos: Ventas del usuario + No Cerradas + Fecha < Hoy
+	// Buscamos: Ventas de la tienda + No Cerradas + Fecha < Hoy
 	pipeline := mongo.Pipeline{
 		{{Key: "$match", Value: bson.D{
-			{Key: "userId", Value: userID},
+			{Key: "storeId", Value: storeID},
 			{Key: "isClosed", Value: false},
 			{Key: "date", Value: bson.D{{Key: "$lt", Value: startOfToday}}}, // Menor a hoy
 		}}},
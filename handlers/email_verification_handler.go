@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/mailer"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sendVerificationEmail emails the user a signed link that confirms the address.
+func sendVerificationEmail(user models.User) {
+	token, err := middleware.IssueLinkToken(middleware.TokenTypeEmailVerify, user.ID.Hex(), user.Email, middleware.EmailVerifyTokenTTL)
+	if err != nil {
+		log.Printf("❌ Error generando link de verificación para %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	link := frontendURL("/verify-email", "token", token)
+	sendMailAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "VerduStock - Confirmá tu email",
+		Body: fmt.Sprintf("Hola %s,\n\nPara activar tu cuenta confirmá tu email entrando a este link (vence en %d horas):\n\n%s",
+			user.Username, int(middleware.EmailVerifyTokenTTL.Hours()), link),
+	})
+}
+
+// MarkExistingUsersVerified marks as verified the accounts created before email
+// verification existed, so they can keep logging in. It is idempotent.
+func MarkExistingUsersVerified() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := database.UserCollection.UpdateMany(ctx,
+		bson.M{"emailVerified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("✅ %d usuarios existentes marcados con email verificado", result.ModifiedCount)
+	}
+	return nil
+}
+
+// VerifyEmailHandler confirms the email of an account from the emailed link.
+func VerifyEmailHandler(c *gin.Context) {
+	var input struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El token es requerido"})
+		return
+	}
+
+	claims, err := middleware.ParseLinkToken(middleware.TokenTypeEmailVerify, input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El link es inválido o ya venció"})
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El link es inválido o ya venció"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// El email del token tiene que seguir siendo el de la cuenta
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": userID, "email": claims.Email},
+		bson.M{"$set": bson.M{"emailVerified": true, "emailVerifiedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar email"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El link es inválido o ya venció"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verificado, ya podés iniciar sesión"})
+}
+
+// ResendVerificationHandler sends a new verification link. Like ForgotPasswordHandler,
+// it always answers the same way so it does not reveal which emails are registered.
+func ResendVerificationHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El email es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if !throttleMailRequest(ctx, c, "verification", input.Email) {
+		return
+	}
+
+	var user models.User
+	err := database.UserCollection.FindOne(ctx, bson.M{"email": strings.TrimSpace(input.Email), "emailVerified": false}, options.FindOne().SetCollation(database.EmailCollation)).Decode(&user)
+	if err == nil {
+		sendVerificationEmail(user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Si la cuenta existe y no está verificada, te enviamos un nuevo link"})
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/mailer"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateInvitationHandler lets the owner invite an email to join the store with a role.
+// The invitee receives a signed link to choose their own password.
+func CreateInvitationHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Email string      `json:"email" binding:"required"`
+		Role  models.Role `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El email es requerido"})
+		return
+	}
+	email := strings.TrimSpace(input.Email)
+	if !strings.Contains(email, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email inválido"})
+		return
+	}
+	if input.Role == "" {
+		input.Role = models.RoleCashier
+	}
+	// La tienda tiene un solo dueño
+	if !input.Role.Valid() || input.Role == models.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rol inválido"})
+		return
+	}
+	inviterID, err := primitive.ObjectIDFromHex(middleware.ActingUserID(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if count, _ := database.UserCollection.CountDocuments(ctx, bson.M{"email": email}, options.Count().SetCollation(database.EmailCollation)); count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El email ya está registrado"})
+		return
+	}
+
+	var store models.Store
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": storeID}).Decode(&store); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tienda no encontrada"})
+		return
+	}
+
+	now := time.Now()
+
+	// Una invitación nueva reemplaza a las pendientes para el mismo email
+	_, _ = database.InvitationsCollection.UpdateMany(ctx,
+		bson.M{"storeId": storeID, "email": email, "acceptedAt": nil, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": now}},
+	)
+
+	invitation := models.Invitation{
+		ID:        primitive.NewObjectID(),
+		StoreID:   storeID,
+		Email:     email,
+		Role:      input.Role,
+		InvitedBy: inviterID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(middleware.InviteTokenTTL),
+	}
+
+	token, err := middleware.IssueLinkToken(middleware.TokenTypeInvite, invitation.ID.Hex(), email, middleware.InviteTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar la invitación"})
+		return
+	}
+	if _, err := database.InvitationsCollection.InsertOne(ctx, invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar la invitación"})
+		return
+	}
+
+	link := frontendURL("/accept-invitation", "token", token)
+	sendMailAsync(mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("VerduStock - Te invitaron a %s", store.Name),
+		Body: fmt.Sprintf("Hola,\n\nTe invitaron a sumarte a %s en VerduStock. Para crear tu cuenta entrá a este link (vence en %d días):\n\n%s\n\nSi no esperabas esta invitación, ignorá este email.",
+			store.Name, int(middleware.InviteTokenTTL.Hours()/24), link),
+	})
+
+	entry := requestAuditEntry(c, models.AuditInviteCreated)
+	entry.TargetType = "invitation"
+	entry.TargetID = invitation.ID.Hex()
+	entry.Details = map[string]interface{}{"email": email, "role": input.Role}
+	recordAudit(ctx, c, entry)
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListInvitationsHandler returns the pending invitations of the store
+func ListInvitationsHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"storeId":    storeID,
+		"acceptedAt": nil,
+		"revokedAt":  nil,
+		"expiresAt":  bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := database.InvitationsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener invitaciones"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	invitations := []models.Invitation{}
+	if err := cursor.All(ctx, &invitations); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar invitaciones"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitationHandler cancels a pending invitation: its link stops working
+func RevokeInvitationHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	invitationID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de invitación inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.InvitationsCollection.UpdateOne(ctx,
+		bson.M{"_id": invitationID, "storeId": storeID, "acceptedAt": nil, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al revocar invitación"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitación no encontrada"})
+		return
+	}
+
+	entry := requestAuditEntry(c, models.AuditInviteRevoked)
+	entry.TargetType = "invitation"
+	entry.TargetID = invitationID.Hex()
+	recordAudit(ctx, c, entry)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitación revocada"})
+}
+
+// AcceptInvitationHandler creates the invitee's account in the inviting store.
+// The link was sent to the invited address, so the email counts as verified.
+func AcceptInvitationHandler(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token, usuario y contraseña son requeridos"})
+		return
+	}
+	if err := validatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := middleware.ParseLinkToken(middleware.TokenTypeInvite, input.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La invitación es inválida o ya venció"})
+		return
+	}
+	invitationID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La invitación es inválida o ya venció"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	// Consumimos la invitación de forma atómica: solo sirve una vez
+	var invitation models.Invitation
+	err = database.InvitationsCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":        invitationID,
+			"email":      claims.Email,
+			"acceptedAt": nil,
+			"revokedAt":  nil,
+			"expiresAt":  bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"acceptedAt": now}},
+	).Decode(&invitation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La invitación es inválida o ya venció"})
+		return
+	}
+
+	user, err := createUser(ctx, newUserParams{
+		Email:         invitation.Email,
+		Username:      input.Username,
+		Password:      input.Password,
+		Role:          invitation.Role,
+		StoreID:       invitation.StoreID,
+		EmailVerified: true,
+	})
+	if err != nil {
+		// Si no se pudo crear la cuenta, la invitación sigue pendiente
+		_, _ = database.InvitationsCollection.UpdateOne(ctx,
+			bson.M{"_id": invitation.ID},
+			bson.M{"$unset": bson.M{"acceptedAt": ""}},
+		)
+		if errors.Is(err, errEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": "El email ya está registrado"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear la cuenta"})
+		return
+	}
+
+	_, _ = database.InvitationsCollection.UpdateOne(ctx,
+		bson.M{"_id": invitation.ID},
+		bson.M{"$set": bson.M{"acceptedBy": user.ID}},
+	)
+
+	entry := userAuditEntry(user, models.AuditInviteUsed)
+	entry.TargetType = "invitation"
+	entry.TargetID = invitation.ID.Hex()
+	entry.Details = map[string]interface{}{"role": invitation.Role}
+	recordAudit(ctx, c, entry)
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Cuenta creada, ya podés iniciar sesión"})
+}
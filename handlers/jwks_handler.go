@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"net/http"
+
+	"verdustock-auth/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKSHandler publishes the public keys that verify our access tokens, so other
+// services can validate them without sharing a secret.
+func JWKSHandler(c *gin.Context) {
+	// Cache corto: una clave nueva se publica antes de empezar a firmar con ella
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": middleware.PublicJWKS()})
+}
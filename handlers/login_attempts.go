@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// attemptPolicy defines how many failures are free and how the lockout grows after that.
+type attemptPolicy struct {
+	FreeFailures int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+}
+
+var (
+	accountAttemptPolicy = attemptPolicy{FreeFailures: 5, BaseLockout: 30 * time.Second, MaxLockout: time.Hour}
+	ipAttemptPolicy      = attemptPolicy{FreeFailures: 20, BaseLockout: time.Minute, MaxLockout: time.Hour}
+
+	// Un PIN tiene pocas combinaciones: se bloquea antes y más fuerte
+	pinAttemptPolicy = attemptPolicy{FreeFailures: 3, BaseLockout: time.Minute, MaxLockout: time.Hour}
+
+	// Pedidos que mandan un email (reseteo, verificación): cada pedido cuenta, no solo los fallidos
+	mailAccountPolicy = attemptPolicy{FreeFailures: 3, BaseLockout: 5 * time.Minute, MaxLockout: 24 * time.Hour}
+	mailIPPolicy      = attemptPolicy{FreeFailures: 10, BaseLockout: 5 * time.Minute, MaxLockout: 24 * time.Hour}
+
+	// Si pasa este tiempo sin fallos, el contador vuelve a cero
+	attemptWindow = 24 * time.Hour
+)
+
+// lockoutFor returns the exponential backoff for the given number of failures.
+func (p attemptPolicy) lockoutFor(failures int) time.Duration {
+	extra := failures - p.FreeFailures
+	if extra <= 0 {
+		return 0
+	}
+	lockout := time.Duration(float64(p.BaseLockout) * math.Pow(2, float64(extra-1)))
+	if lockout <= 0 || lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// mailAttemptKey namespaces a login attempt key for the requests of one kind of email,
+// so that mailing does not lock the login (and vice versa).
+func mailAttemptKey(kind, key string) string {
+	return "mail:" + kind + ":" + key
+}
+
+// throttleMailRequest counts a request that sends an email to that address, per address and
+// per IP. It answers 429 (or 503 if the counters cannot be read) and returns false when the
+// request has to be dropped.
+func throttleMailRequest(ctx context.Context, c *gin.Context, kind, email string) bool {
+	checks := []struct {
+		key    string
+		policy attemptPolicy
+	}{
+		{mailAttemptKey(kind, accountAttemptKey(email)), mailAccountPolicy},
+		{mailAttemptKey(kind, ipAttemptKey(c.ClientIP())), mailIPPolicy},
+	}
+
+	keys := make([]string, 0, len(checks))
+	for _, check := range checks {
+		keys = append(keys, check.key)
+	}
+	if !checkLockout(ctx, c, keys...) {
+		return false
+	}
+
+	for _, check := range checks {
+		if _, _, err := recordFailedAttempt(ctx, check.key, check.policy); err != nil {
+			log.Printf("❌ Error registrando pedido de email (%s): %v", check.key, err)
+		}
+	}
+	return true
+}
+
+// checkLockout answers 429 when any of the keys is locked and returns false. If the
+// counters cannot be read it answers 503: failing open would disable the protection.
+func checkLockout(ctx context.Context, c *gin.Context, keys ...string) bool {
+	retryAfter, err := lockedFor(ctx, keys...)
+	if err != nil {
+		log.Printf("❌ Error leyendo bloqueos (%s): %v", strings.Join(keys, ", "), err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No pudimos procesar el pedido, probá de nuevo en unos minutos"})
+		return false
+	}
+	if retryAfter > 0 {
+		abortTooManyAttempts(c, retryAfter)
+		return false
+	}
+	return true
+}
+
+// lockedFor returns how long the longest active lockout among the keys still lasts.
+func lockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	cursor, err := database.LoginAttemptsCollection.Find(ctx, bson.M{
+		"key":         bson.M{"$in": keys},
+		"lockedUntil": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var attempts []models.LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return 0, err
+	}
+
+	var longest time.Duration
+	for _, a := range attempts {
+		if remaining := time.Until(*a.LockedUntil); remaining > longest {
+			longest = remaining
+		}
+	}
+	return longest, nil
+}
+
+// recordFailedAttempt increments the counter for the key and, once over the free
+// failures, locks it. It returns the lockout applied (0 if none).
+func recordFailedAttempt(ctx context.Context, key string, policy attemptPolicy) (time.Duration, int, error) {
+	now := time.Now()
+
+	// Fallos viejos no cuentan
+	_, err := database.LoginAttemptsCollection.UpdateOne(ctx,
+		bson.M{"key": key, "lastFailureAt": bson.M{"$lt": now.Add(-attemptWindow)}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var attempt models.LoginAttempt
+	err = database.LoginAttemptsCollection.FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"lastFailureAt": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lockout := policy.lockoutFor(attempt.Failures)
+	if lockout == 0 {
+		return 0, attempt.Failures, nil
+	}
+
+	_, err = database.LoginAttemptsCollection.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$set": bson.M{"lockedUntil": now.Add(lockout)},
+			"$inc": bson.M{"lockouts": 1},
+		},
+	)
+	return lockout, attempt.Failures, err
+}
+
+// abortTooManyAttempts answers 429 with Retry-After in seconds.
+func abortTooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      fmt.Sprintf("Demasiados intentos fallidos. Probá de nuevo en %d segundos", seconds),
+		"retryAfter": seconds,
+	})
+}
+
+// resetAttempts clears the counter of a key after a successful login.
+func resetAttempts(ctx context.Context, key string) error {
+	_, err := database.LoginAttemptsCollection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
+
+// registerLoginFailure counts the failure for the account and the IP and audits any lockout.
+func registerLoginFailure(ctx context.Context, c *gin.Context, email string) {
+	checks := []struct {
+		key    string
+		policy attemptPolicy
+	}{
+		{accountAttemptKey(email), accountAttemptPolicy},
+		{ipAttemptKey(c.ClientIP()), ipAttemptPolicy},
+	}
+
+	for _, check := range checks {
+		lockout, failures, err := recordFailedAttempt(ctx, check.key, check.policy)
+		if err != nil {
+			log.Printf("❌ Error registrando intento fallido (%s): %v", check.key, err)
+			continue
+		}
+		if lockout > 0 {
+			recordAudit(ctx, c, models.AuditEntry{
+				ActorEmail: email,
+				Action:     models.AuditLoginLockout,
+				TargetType: "login_attempt",
+				TargetID:   check.key,
+				Details: map[string]interface{}{
+					"failures":       failures,
+					"lockoutSeconds": int(lockout.Seconds()),
+				},
+			})
+		}
+	}
+}
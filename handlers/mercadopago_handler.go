@@ -53,15 +53,9 @@ type MPOAuthResponse struct {
 // 1. LINK ACCOUNT HANDLER (Igual que antes)
 // ==========================================
 func LinkMPAccountHandler(c *gin.Context) {
-	// ... (Tu código de LinkMPAccountHandler estaba bien, déjalo igual o cópialo si quieres asegurarte)
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-	userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid User ID"})
+	// La cuenta de Mercado Pago se vincula a la tienda, no al usuario
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -123,12 +117,18 @@ func LinkMPAccountHandler(c *gin.Context) {
 		},
 	}
 
-	_, err = database.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	_, err = database.StoresCollection.UpdateOne(ctx, bson.M{"_id": storeID}, update)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save MP credentials"})
 		return
 	}
 
+	entry := requestAuditEntry(c, models.AuditMPLinked)
+	entry.TargetType = "store"
+	entry.TargetID = storeID.Hex()
+	entry.Details = map[string]interface{}{"mpUserId": mpData.UserID}
+	recordAudit(ctx, c, entry)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Mercado Pago account linked successfully!"})
 }
 
@@ -152,19 +152,19 @@ func HandleMPWebhook(c *gin.Context) {
 		return
 	}
 
-	// 2. BUSCAR AL USUARIO POR SU ID DE MERCADO PAGO
+	// 2. BUSCAR LA TIENDA POR SU ID DE MERCADO PAGO
 	// Aquí está la magia: Buscamos quién tiene este ID vinculado en Mongo
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var user models.User
-	err := database.UserCollection.FindOne(ctx, bson.M{"mpAccount.userId": req.UserID}).Decode(&user)
+	var store models.Store
+	err := database.StoresCollection.FindOne(ctx, bson.M{"mpAccount.userId": req.UserID}).Decode(&store)
 
 	if err != nil {
-		// Si no encontramos al usuario, respondemos 200 (OK) para que MP no reintente,
+		// Si no encontramos la tienda, respondemos 200 (OK) para que MP no reintente,
 		// pero logueamos el error.
-		fmt.Printf("⚠️ Usuario no encontrado para MP User ID: %d\n", req.UserID)
-		c.JSON(http.StatusOK, gin.H{"message": "Usuario no encontrado, evento ignorado"})
+		fmt.Printf("⚠️ Tienda no encontrada para MP User ID: %d\n", req.UserID)
+		c.JSON(http.StatusOK, gin.H{"message": "Tienda no encontrada, evento ignorado"})
 		return
 	}
 
@@ -178,7 +178,7 @@ func HandleMPWebhook(c *gin.Context) {
 		return
 	}
 
-	mpReq.Header.Set("Authorization", "Bearer "+user.MPAccount.AccessToken)
+	mpReq.Header.Set("Authorization", "Bearer "+store.MPAccount.AccessToken)
 
 	resp, err := client.Do(mpReq)
 	if err != nil {
@@ -222,7 +222,7 @@ func HandleMPWebhook(c *gin.Context) {
 
 	mpPayment := models.MPPayment{
 		ID:          primitive.NewObjectID(),
-		UserID:      user.ID, // Usamos el ID del usuario que encontramos en la DB
+		StoreID:     store.ID, // Usamos el ID de la tienda que encontramos en la DB
 		MPPaymentID: payment.ID,
 		Amount:      payment.TransactionAmount,
 		PayerEmail:  payment.Payer.Email,
@@ -241,7 +241,8 @@ func HandleMPWebhook(c *gin.Context) {
 	// 7. Crear la Venta
 	sell := models.Sell{
 		ID:       primitive.NewObjectID(),
-		UserID:   user.ID,
+		StoreID:  store.ID,
+		UserID:   store.OwnerID,
 		Amount:   payment.TransactionAmount,
 		Date:     time.Now(),
 		Type:     "transfer",
@@ -0,0 +1,504 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+	"verdustock-auth/totp"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaIssuer         = "VerduStock"
+	recoveryCodeCount = 10
+)
+
+var (
+	errMFANotEnrolling = errors.New("no hay un enrolamiento de segundo factor en curso")
+	errMFAInvalidCode  = errors.New("código inválido")
+)
+
+func mfaAttemptKey(userID primitive.ObjectID) string {
+	return "mfa:" + userID.Hex()
+}
+
+// storeRequiresMFA reports whether the store forces every member to use a second factor.
+func storeRequiresMFA(ctx context.Context, storeID primitive.ObjectID) bool {
+	var store models.Store
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": storeID}).Decode(&store); err != nil {
+		return false
+	}
+	return store.RequireMFA
+}
+
+// beginMFAEnrollment stores a new pending secret and returns it with its provisioning URI.
+func beginMFAEnrollment(ctx context.Context, user models.User) (string, string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"mfaPendingSecret": secret}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totp.ProvisioningURI(secret, user.Email, mfaIssuer), nil
+}
+
+// generateRecoveryCodes returns new recovery codes in clear text and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(raw[:4] + "-" + raw[4:8])
+		codes = append(codes, code)
+		hashes = append(hashes, middleware.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// confirmMFAEnrollment checks the code against the pending secret, enables MFA and
+// returns freshly generated recovery codes.
+func confirmMFAEnrollment(ctx context.Context, user models.User, code string) ([]string, error) {
+	if user.MFAPendingSecret == "" {
+		return nil, errMFANotEnrolling
+	}
+
+	step, ok := totp.Validate(code, user.MFAPendingSecret, time.Now())
+	if !ok {
+		return nil, errMFAInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"mfaEnabled":    true,
+				"mfaSecret":     user.MFAPendingSecret,
+				"mfaLastStep":   step,
+				"recoveryCodes": hashes,
+			},
+			"$unset": bson.M{"mfaPendingSecret": ""},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifyMFACode validates a TOTP code (or, if given, a recovery code) for a user with MFA enabled.
+// Codes are single use: a TOTP step or recovery code cannot be replayed.
+func verifyMFACode(ctx context.Context, user models.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		hash := middleware.HashToken(strings.ToLower(strings.TrimSpace(recoveryCode)))
+		result, err := database.UserCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "recoveryCodes": hash},
+			bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	step, ok := totp.Validate(code, user.MFASecret, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Solo acepta el paso si es posterior al último usado (evita reutilizar un código)
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "$or": bson.A{
+			bson.M{"mfaLastStep": bson.M{"$lt": step}},
+			bson.M{"mfaLastStep": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"mfaLastStep": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// loadCurrentUser fetches the logged in user; on failure it writes the error response.
+func loadCurrentUser(ctx context.Context, c *gin.Context) (models.User, bool) {
+	var user models.User
+	userID, err := primitive.ObjectIDFromHex(c.GetString("userId"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return user, false
+	}
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return user, false
+	}
+	return user, true
+}
+
+// EnrollMFAHandler starts the TOTP enrollment of the logged in user
+func EnrollMFAHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := loadCurrentUser(ctx, c)
+	if !ok {
+		return
+	}
+	if user.MFAEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "El segundo factor ya está activado"})
+		return
+	}
+
+	secret, uri, err := beginMFAEnrollment(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar el enrolamiento"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": uri,
+	})
+}
+
+// ConfirmMFAHandler enables MFA once the user proves the authenticator app works
+func ConfirmMFAHandler(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El código es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := loadCurrentUser(ctx, c)
+	if !ok {
+		return
+	}
+
+	codes, err := confirmMFAEnrollment(ctx, user, input.Code)
+	switch {
+	case errors.Is(err, errMFANotEnrolling):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Primero hay que iniciar el enrolamiento"})
+		return
+	case errors.Is(err, errMFAInvalidCode):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Código inválido"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al activar el segundo factor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Segundo factor activado. Guardá los códigos de recuperación en un lugar seguro",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableMFAHandler turns MFA off after checking the password and a current code
+func DisableMFAHandler(c *gin.Context) {
+	var input struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La contraseña es requerida"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := loadCurrentUser(ctx, c)
+	if !ok {
+		return
+	}
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El segundo factor no está activado"})
+		return
+	}
+	if storeRequiresMFA(ctx, user.StoreID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "La tienda exige segundo factor para todos sus miembros"})
+		return
+	}
+
+	// Mismo límite que el login con segundo factor: si no, con una sesión se prueban códigos sin fin
+	attemptKey := mfaAttemptKey(user.ID)
+	if !checkLockout(ctx, c, attemptKey) {
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		_, _, _ = recordFailedAttempt(ctx, attemptKey, accountAttemptPolicy)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Contraseña incorrecta"})
+		return
+	}
+	valid, err := verifyMFACode(ctx, user, input.Code, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar el código"})
+		return
+	}
+	if !valid {
+		_, _, _ = recordFailedAttempt(ctx, attemptKey, accountAttemptPolicy)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido"})
+		return
+	}
+	_ = resetAttempts(ctx, attemptKey)
+
+	_, err = database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"mfaEnabled": false},
+			"$unset": bson.M{"mfaSecret": "", "mfaPendingSecret": "", "mfaLastStep": "", "recoveryCodes": ""},
+		},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al desactivar el segundo factor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Segundo factor desactivado"})
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes (the old ones stop working)
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var input struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El código es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, ok := loadCurrentUser(ctx, c)
+	if !ok {
+		return
+	}
+	if !user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El segundo factor no está activado"})
+		return
+	}
+	attemptKey := mfaAttemptKey(user.ID)
+	if !checkLockout(ctx, c, attemptKey) {
+		return
+	}
+	valid, err := verifyMFACode(ctx, user, input.Code, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar el código"})
+		return
+	}
+	if !valid {
+		_, _, _ = recordFailedAttempt(ctx, attemptKey, accountAttemptPolicy)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido"})
+		return
+	}
+	_ = resetAttempts(ctx, attemptKey)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar códigos"})
+		return
+	}
+	_, err = database.UserCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"recoveryCodes": hashes}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar códigos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// loadMFAPendingUser validates the "mfa pending" token and loads its user.
+func loadMFAPendingUser(ctx context.Context, c *gin.Context, mfaToken string) (*middleware.MFAClaims, models.User, bool) {
+	var user models.User
+
+	claims, err := middleware.ParseMFAToken(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "El paso de verificación venció, iniciá sesión de nuevo"})
+		return nil, user, false
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de userId inválido"})
+		return nil, user, false
+	}
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
+		return nil, user, false
+	}
+	return claims, user, true
+}
+
+// LoginMFAEnrollHandler starts the enrollment during login, for members of a store
+// that requires MFA and who have not enrolled yet
+func LoginMFAEnrollHandler(c *gin.Context) {
+	var input struct {
+		MFAToken string `json:"mfaToken" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims, user, ok := loadMFAPendingUser(ctx, c, input.MFAToken)
+	if !ok {
+		return
+	}
+	if !claims.Enroll || user.MFAEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El usuario ya tiene segundo factor"})
+		return
+	}
+
+	secret, uri, err := beginMFAEnrollment(ctx, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar el enrolamiento"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":          secret,
+		"provisioningUri": uri,
+	})
+}
+
+// LoginMFAHandler completes the login with a TOTP or recovery code. If the user was
+// enrolling, the code also confirms the enrollment and the recovery codes are returned.
+func LoginMFAHandler(c *gin.Context) {
+	var input struct {
+		MFAToken     string `json:"mfaToken" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El código es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	claims, user, ok := loadMFAPendingUser(ctx, c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	// Los códigos son cortos: limitamos los intentos igual que con la contraseña
+	attemptKey := mfaAttemptKey(user.ID)
+	if !checkLockout(ctx, c, attemptKey) {
+		return
+	}
+
+	var recoveryCodes []string
+	if user.MFAEnabled {
+		valid, err := verifyMFACode(ctx, user, input.Code, input.RecoveryCode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar el código"})
+			return
+		}
+		if !valid {
+			_, _, _ = recordFailedAttempt(ctx, attemptKey, accountAttemptPolicy)
+			auditLoginFailure(ctx, c, user.Email, &user, "invalid_mfa_code")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido"})
+			return
+		}
+	} else {
+		if !claims.Enroll {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El usuario no tiene segundo factor"})
+			return
+		}
+		codes, err := confirmMFAEnrollment(ctx, user, input.Code)
+		switch {
+		case errors.Is(err, errMFANotEnrolling):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Primero hay que iniciar el enrolamiento"})
+			return
+		case errors.Is(err, errMFAInvalidCode):
+			_, _, _ = recordFailedAttempt(ctx, attemptKey, accountAttemptPolicy)
+			auditLoginFailure(ctx, c, user.Email, &user, "invalid_mfa_code")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Código inválido"})
+			return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al activar el segundo factor"})
+			return
+		}
+		recoveryCodes = codes
+	}
+
+	_ = resetAttempts(ctx, attemptKey)
+
+	if err := startSession(ctx, c, user, claims.RememberMe, claims.Device); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al iniciar sesión"})
+		return
+	}
+
+	entry := userAuditEntry(user, models.AuditLogin)
+	entry.Details = map[string]interface{}{"mfa": true, "rememberMe": claims.RememberMe, "recoveryCode": input.RecoveryCode != ""}
+	recordAudit(ctx, c, entry)
+
+	response := gin.H{"message": "Logueado correctamente"}
+	if recoveryCodes != nil {
+		response["recoveryCodes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateStoreSecurityHandler lets the owner require MFA for every member of the store
+func UpdateStoreSecurityHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		RequireMFA *bool `json:"requireMfa" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := database.StoresCollection.UpdateOne(ctx,
+		bson.M{"_id": storeID},
+		bson.M{"$set": bson.M{"requireMfa": *input.RequireMFA}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar la tienda"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Configuración de seguridad actualizada"})
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"verdustock-auth/database"
+	"verdustock-auth/mailer"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL  = time.Hour
+	minPasswordLength = 8
+)
+
+// validatePassword applies the minimum password policy.
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("la contraseña debe tener al menos %d caracteres", minPasswordLength)
+	}
+	if strings.TrimSpace(password) == "" {
+		return errors.New("la contraseña no puede estar vacía")
+	}
+	return nil
+}
+
+// frontendURL builds a link to the frontend, e.g. frontendURL("/reset-password", "token", t).
+func frontendURL(path string, query ...string) string {
+	base := os.Getenv("FRONTEND_URL")
+	if base == "" {
+		base = "http://localhost:4200"
+	}
+
+	values := url.Values{}
+	for i := 0; i+1 < len(query); i += 2 {
+		values.Set(query[i], query[i+1])
+	}
+
+	link := strings.TrimRight(base, "/") + path
+	if len(values) > 0 {
+		link += "?" + values.Encode()
+	}
+	return link
+}
+
+// sendMailAsync sends the email in the background so the response time does not
+// depend on the mail server (nor reveal whether the account exists).
+func sendMailAsync(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := mailer.Send(ctx, msg); err != nil {
+			log.Printf("❌ Error enviando email a %s: %v", msg.To, err)
+		}
+	}()
+}
+
+// createPasswordReset invalidates previous reset tokens of the user and returns a new one.
+func createPasswordReset(ctx context.Context, userID primitive.ObjectID, ip string) (string, error) {
+	now := time.Now()
+	_, err := database.PasswordResetsCollection.UpdateMany(ctx,
+		bson.M{"userId": userID, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := middleware.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	reset := models.PasswordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		TokenHash: middleware.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(passwordResetTTL),
+		RequestIP: ip,
+	}
+	if _, err := database.PasswordResetsCollection.InsertOne(ctx, reset); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendPasswordResetEmail creates a reset token for the user and emails the link.
+func sendPasswordResetEmail(ctx context.Context, user models.User, ip string) error {
+	token, err := createPasswordReset(ctx, user.ID, ip)
+	if err != nil {
+		return err
+	}
+
+	link := frontendURL("/reset-password", "token", token)
+	sendMailAsync(mailer.Message{
+		To:      user.Email,
+		Subject: "VerduStock - Restablecer contraseña",
+		Body: fmt.Sprintf("Hola %s,\n\nPara elegir una nueva contraseña entrá a este link (vence en %d minutos):\n\n%s\n\nSi no lo pediste, ignorá este email.",
+			user.Username, int(passwordResetTTL.Minutes()), link),
+	})
+	return nil
+}
+
+// ForgotPasswordHandler emails a single-use reset link. It always answers the same
+// way so it cannot be used to find out which emails are registered.
+func ForgotPasswordHandler(c *gin.Context) {
+	var input struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El email es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Cada pedido manda un email: sin límite se puede usar para llenarle la casilla a cualquiera
+	if !throttleMailRequest(ctx, c, "password_reset", input.Email) {
+		return
+	}
+
+	var user models.User
+	err := database.UserCollection.FindOne(ctx, bson.M{"email": strings.TrimSpace(input.Email)}, options.FindOne().SetCollation(database.EmailCollation)).Decode(&user)
+	if err == nil {
+		if err := sendPasswordResetEmail(ctx, user, c.ClientIP()); err != nil {
+			log.Printf("❌ Error creando token de reseteo para %s: %v", user.ID.Hex(), err)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		log.Printf("❌ Error buscando usuario para reseteo: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Si el email está registrado, te enviamos un link para restablecer la contraseña"})
+}
+
+// ResetPasswordHandler consumes a reset token, sets the new password and closes every session.
+func ResetPasswordHandler(c *gin.Context) {
+	var input struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token y contraseña son requeridos"})
+		return
+	}
+	if err := validatePassword(input.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	// Consumimos el token de forma atómica: solo sirve una vez
+	var reset models.PasswordReset
+	err := database.PasswordResetsCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"tokenHash": middleware.HashToken(input.Token),
+			"usedAt":    nil,
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&reset)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El link es inválido o ya venció"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar contraseña"})
+		return
+	}
+
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": reset.UserID},
+		bson.M{
+			// El link llegó a su casilla: el email queda verificado
+			"$set":   bson.M{"password": string(hash), "emailVerified": true},
+			"$unset": bson.M{"passwordResetRequired": ""},
+		},
+	)
+	if err != nil || result.MatchedCount == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar contraseña"})
+		return
+	}
+
+	// Si alguien tenía la sesión abierta con la contraseña vieja, la cerramos
+	if _, err := revokeUserSessions(ctx, reset.UserID, nil, "password_reset"); err != nil {
+		log.Printf("❌ Error revocando sesiones de %s: %v", reset.UserID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contraseña actualizada correctamente"})
+}
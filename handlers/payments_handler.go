@@ -10,28 +10,25 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // GetMPPaymentsHandler obtiene el historial de pagos de Mercado Pago
 func GetMPPaymentsHandler(c *gin.Context) {
-	// 1. Obtener ID del usuario logueado
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+	// 1. Obtener la tienda del usuario logueado
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// 2. Buscar en la colección MPPayments
-	// Filtramos por userId y ordenamos por fecha descendente (lo más nuevo arriba)
+	// Filtramos por storeId y ordenamos por fecha descendente (lo más nuevo arriba)
 	opts := options.Find().SetSort(bson.D{{Key: "receivedAt", Value: -1}})
 
-	cursor, err := database.MPPaymentsCollection.Find(ctx, bson.M{"userId": userID}, opts)
+	cursor, err := database.MPPaymentsCollection.Find(ctx, bson.M{"storeId": storeID}, opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener pagos"})
 		return
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPINLength = 4
+	maxPINLength = 8
+)
+
+// pinAttemptKey counts wrong PINs against a member, wherever they are tried.
+func pinAttemptKey(userID primitive.ObjectID) string {
+	return "pin:" + userID.Hex()
+}
+
+// terminalAttemptKey counts wrong PINs on one device session, whatever member they target.
+func terminalAttemptKey(sessionID string) string {
+	return "pin-session:" + sessionID
+}
+
+// validatePIN accepts only numeric PINs between minPINLength and maxPINLength digits.
+func validatePIN(pin string) error {
+	if len(pin) < minPINLength || len(pin) > maxPINLength {
+		return fmt.Errorf("el PIN debe tener entre %d y %d dígitos", minPINLength, maxPINLength)
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return fmt.Errorf("el PIN solo puede tener números")
+		}
+	}
+	return nil
+}
+
+// canSwitchTo reports whether the member can be switched in on a terminal of the store.
+func canSwitchTo(user models.User, storeID primitive.ObjectID) bool {
+	return user.StoreID == storeID && !user.Disabled && user.PINHash != ""
+}
+
+// SetMemberPINHandler lets the owner set the quick-switch PIN of a member (including their own)
+func SetMemberPINHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+
+	var input struct {
+		PIN string `json:"pin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El PIN es requerido"})
+		return
+	}
+	if err := validatePIN(input.PIN); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.PIN), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar PIN"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": memberID, "storeId": storeID},
+		bson.M{"$set": bson.M{"pinHash": string(hash)}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar PIN"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado en la tienda"})
+		return
+	}
+
+	// Un PIN nuevo arranca sin intentos fallidos acumulados
+	_ = resetAttempts(ctx, pinAttemptKey(memberID))
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN actualizado correctamente"})
+}
+
+// ClearMemberPINHandler removes the PIN of a member: they can no longer be switched in
+func ClearMemberPINHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": memberID, "storeId": storeID},
+		bson.M{"$unset": bson.M{"pinHash": ""}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al borrar PIN"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado en la tienda"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN eliminado"})
+}
+
+// SwitchUserHandler swaps the member operating a shared terminal. The device session
+// stays the owner's; only the acting user (and therefore the role) changes.
+func SwitchUserHandler(c *gin.Context) {
+	var input struct {
+		UserID string `json:"userId" binding:"required"`
+		PIN    string `json:"pin" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Usuario y PIN son requeridos"})
+		return
+	}
+	targetID, err := primitive.ObjectIDFromHex(input.UserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+	sessionID, err := primitive.ObjectIDFromHex(c.GetString("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión inválida"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// El cambio rápido solo existe en terminales abiertas por el dueño de la tienda
+	holder, ok := loadCurrentUser(ctx, c)
+	if !ok {
+		return
+	}
+	if holder.GetRole() != models.RoleOwner {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El cambio de usuario solo está disponible en sesiones del dueño de la tienda"})
+		return
+	}
+	if holder.PINHash == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Configurá tu propio PIN antes de usar el cambio de usuario"})
+		return
+	}
+
+	targetKey := pinAttemptKey(targetID)
+	terminalKey := terminalAttemptKey(sessionID.Hex())
+	if !checkLockout(ctx, c, targetKey, terminalKey) {
+		return
+	}
+
+	var target models.User
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": targetID}).Decode(&target); err != nil || !canSwitchTo(target, holder.StoreID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no disponible para esta terminal"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(target.PINHash), []byte(input.PIN)); err != nil {
+		var retryAfter time.Duration
+		for _, key := range []string{targetKey, terminalKey} {
+			lockout, _, err := recordFailedAttempt(ctx, key, pinAttemptPolicy)
+			if err != nil {
+				log.Printf("❌ Error registrando intento fallido (%s): %v", key, err)
+				continue
+			}
+			if lockout > retryAfter {
+				retryAfter = lockout
+			}
+		}
+		if retryAfter > 0 {
+			abortTooManyAttempts(c, retryAfter)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "PIN incorrecto"})
+		return
+	}
+
+	_ = resetAttempts(ctx, targetKey)
+	_ = resetAttempts(ctx, terminalKey)
+
+	// Volver al titular limpia el usuario activo
+	update := bson.M{"$set": bson.M{"actingUserId": target.ID}}
+	if target.ID == holder.ID {
+		update = bson.M{"$unset": bson.M{"actingUserId": ""}}
+	}
+
+	var session models.Session
+	err = database.SessionsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": sessionID, "userId": holder.ID, "revokedAt": nil},
+		update,
+	).Decode(&session)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión revocada o expirada"})
+		return
+	}
+
+	if err := setAccessCookie(c, holder, target, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar token"})
+		return
+	}
+
+	details := map[string]interface{}{"sessionId": session.ID.Hex()}
+	if session.ActingUserID != nil {
+		details["from"] = session.ActingUserID.Hex()
+	}
+	entry := userAuditEntry(holder, models.AuditUserSwitch)
+	entry.TargetType = "user"
+	entry.TargetID = target.ID.Hex()
+	entry.Details = details
+	recordAudit(ctx, c, entry)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Usuario cambiado correctamente",
+		"user": gin.H{
+			"id":       target.ID.Hex(),
+			"username": target.Username,
+			"role":     target.GetRole(),
+		},
+	})
+}
+
+// actingUserFromContext returns the ObjectID of who is operating (see middleware.ActingUserID).
+func actingUserFromContext(c *gin.Context) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(middleware.ActingUserID(c))
+	return id
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// El código de una lista es la clave en Product.ListPrices: nada de puntos ni "$"
+var priceListCodePattern = regexp.MustCompile(`^[a-z0-9_-]{1,20}$`)
+
+// storePriceLists returns the codes of the price lists of the store.
+func storePriceLists(ctx context.Context, storeID primitive.ObjectID) (map[string]bool, error) {
+	var store models.Store
+	err := database.StoresCollection.FindOne(ctx,
+		bson.M{"_id": storeID},
+		options.FindOne().SetProjection(bson.M{"priceLists": 1}),
+	).Decode(&store)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(map[string]bool, len(store.PriceLists))
+	for _, list := range store.PriceLists {
+		codes[list.Code] = true
+	}
+	return codes, nil
+}
+
+// validatePrices checks that the prices are not negative and that every list price
+// belongs to a price list of the store. A nil list price means "remove it".
+func validatePrices(ctx context.Context, storeID primitive.ObjectID, cost, sale *float64, listPrices map[string]*float64) error {
+	if cost != nil && *cost < 0 {
+		return validationError{"El costo no puede ser negativo"}
+	}
+	if sale != nil && *sale < 0 {
+		return validationError{"El precio de venta no puede ser negativo"}
+	}
+	if len(listPrices) == 0 {
+		return nil
+	}
+
+	codes, err := storePriceLists(ctx, storeID)
+	if err != nil {
+		return err
+	}
+	for code, price := range listPrices {
+		if !codes[code] {
+			return validationError{fmt.Sprintf("La lista de precios %q no existe", code)}
+		}
+		if price != nil && *price < 0 {
+			return validationError{fmt.Sprintf("El precio de la lista %q no puede ser negativo", code)}
+		}
+	}
+	return nil
+}
+
+// priceChanges lists what changed between two versions of a product's prices.
+func priceChanges(before, after models.Product) []models.PriceChange {
+	var changes []models.PriceChange
+	add := func(kind models.PriceKind, list string, oldPrice, newPrice float64) {
+		if oldPrice == newPrice {
+			return
+		}
+		changes = append(changes, models.PriceChange{
+			StoreID:     after.StoreID,
+			ProductID:   after.ID,
+			ProductName: after.Name,
+			Kind:        kind,
+			PriceList:   list,
+			OldPrice:    oldPrice,
+			NewPrice:    newPrice,
+		})
+	}
+
+	add(models.PriceKindCost, "", before.CostPrice, after.CostPrice)
+	add(models.PriceKindSale, "", before.SalePrice, after.SalePrice)
+
+	codes := map[string]bool{}
+	for code := range before.ListPrices {
+		codes[code] = true
+	}
+	for code := range after.ListPrices {
+		codes[code] = true
+	}
+	sorted := make([]string, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Strings(sorted)
+	for _, code := range sorted {
+		add(models.PriceKindList, code, before.ListPrices[code], after.ListPrices[code])
+	}
+	return changes
+}
+
+// recordPriceChanges stores the price log. A failure is only logged: the price is already saved.
+func recordPriceChanges(ctx context.Context, changes []models.PriceChange, userID primitive.ObjectID, source string, operationID *primitive.ObjectID) {
+	if len(changes) == 0 {
+		return
+	}
+
+	now := time.Now()
+	documents := make([]interface{}, 0, len(changes))
+	for _, change := range changes {
+		change.ID = primitive.NewObjectID()
+		change.UserID = userID
+		change.Source = source
+		change.OperationID = operationID
+		change.CreatedAt = now
+		documents = append(documents, change)
+	}
+	if _, err := database.PriceChangesCollection.InsertMany(ctx, documents); err != nil {
+		log.Printf("❌ Error registrando cambios de precio: %v", err)
+	}
+}
+
+// CreatePriceListHandler adds a price list to the store
+func CreatePriceListHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input models.PriceList
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+	input.Code = strings.ToLower(strings.TrimSpace(input.Code))
+	input.Name = strings.TrimSpace(input.Name)
+	if !priceListCodePattern.MatchString(input.Code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El código solo puede tener minúsculas, números, \"-\" y \"_\" (hasta 20)"})
+		return
+	}
+	if input.Name == "" {
+		input.Name = input.Code
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.StoresCollection.UpdateOne(ctx,
+		bson.M{"_id": storeID, "priceLists.code": bson.M{"$ne": input.Code}},
+		bson.M{"$push": bson.M{"priceLists": input}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear lista de precios"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Ya existe una lista con ese código"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, input)
+}
+
+// DeletePriceListHandler removes a price list and its prices from every product of the store
+func DeletePriceListHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	code := c.Param("code")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.StoresCollection.UpdateOne(ctx,
+		bson.M{"_id": storeID, "priceLists.code": code},
+		bson.M{"$pull": bson.M{"priceLists": bson.M{"code": code}}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar lista de precios"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lista de precios no encontrada"})
+		return
+	}
+
+	field := "listPrices." + code
+	if _, err := database.StockCollection.UpdateMany(ctx,
+		bson.M{"storeId": storeID, field: bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{field: ""}},
+	); err != nil {
+		log.Printf("❌ Error quitando la lista %s de los productos: %v", code, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lista de precios eliminada"})
+}
+
+// GetPriceHistoryHandler returns the price changes of a product, newest first.
+// Filters: kind, from, to, page, limit.
+func GetPriceHistoryHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	page, limit := paginationParams(c)
+
+	filter := bson.M{"storeId": storeID, "productId": productID}
+	if kind := c.Query("kind"); kind != "" {
+		filter["kind"] = kind
+	}
+	createdAt, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if createdAt != nil {
+		filter["createdAt"] = createdAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.PriceChangesCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar cambios de precio"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.PriceChangesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener cambios de precio"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	changes := []models.PriceChange{}
+	if err := cursor.All(ctx, &changes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar cambios de precio"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": changes,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
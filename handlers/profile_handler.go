@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const maxUsernameLength = 50
+
+// UpdateProfileHandler updates the preferences (theme, language) and the username of the logged in user
+func UpdateProfileHandler(c *gin.Context) {
+	userIDStr, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var input struct {
+		Username *string `json:"username"`
+		Theme    *string `json:"theme"`
+		Language *string `json:"language"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	update := bson.M{}
+	if input.Username != nil {
+		username := strings.TrimSpace(*input.Username)
+		if username == "" || utf8.RuneCountInString(username) > maxUsernameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nombre de usuario inválido"})
+			return
+		}
+		update["username"] = username
+	}
+	if input.Theme != nil {
+		if !slices.Contains(models.AllowedThemes, *input.Theme) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tema inválido", "allowed": models.AllowedThemes})
+			return
+		}
+		update["theme"] = *input.Theme
+	}
+	if input.Language != nil {
+		if !slices.Contains(models.AllowedLanguages, *input.Language) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idioma inválido", "allowed": models.AllowedLanguages})
+			return
+		}
+		update["language"] = *input.Language
+	}
+
+	if len(update) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No se enviaron datos para actualizar"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": update})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar perfil"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Perfil actualizado correctamente"})
+}
+
+// ChangePasswordHandler changes the password after checking the current one,
+// and closes every other session of the user
+func ChangePasswordHandler(c *gin.Context) {
+	userIDStr, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	var input struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La contraseña actual y la nueva son requeridas"})
+		return
+	}
+	if err := validatePassword(input.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "La contraseña actual es incorrecta"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar contraseña"})
+		return
+	}
+
+	_, err = database.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": string(hash)}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar contraseña"})
+		return
+	}
+
+	// Dejamos viva solo la sesión desde la que se cambió la contraseña
+	var except *primitive.ObjectID
+	if sessionID, err := primitive.ObjectIDFromHex(c.GetString("sessionId")); err == nil {
+		except = &sessionID
+	}
+	revoked, err := revokeUserSessions(ctx, userID, except, "password_changed")
+	if err != nil {
+		log.Printf("❌ Error revocando sesiones de %s: %v", userID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "Contraseña actualizada correctamente",
+		"revokedSessions": revoked,
+	})
+}
@@ -0,0 +1,601 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const receiptReferenceType = "purchase_receipt"
+
+// validationError is an input problem that is answered with a 400 and its message.
+type validationError struct {
+	msg string
+}
+
+func (e validationError) Error() string { return e.msg }
+
+// receiptInput is the body to create or edit a draft receipt.
+type receiptInput struct {
+	SupplierID string     `json:"supplierId"` // Proveedor del directorio; si no, Supplier es texto libre
+	Supplier   string     `json:"supplier"`
+	Date       *time.Time `json:"date"`
+	Notes      string     `json:"notes"`
+	Lines      []struct {
+		ProductID   string             `json:"productId"`
+		Quantity    float64            `json:"quantity"`
+		Measurement models.Measurement `json:"measurement"`
+		UnitCost    float64            `json:"unitCost"`
+	} `json:"lines"`
+}
+
+// roundMoney rounds an amount to cents.
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// buildReceiptLines validates the lines against the store products and returns them with the total.
+func buildReceiptLines(ctx context.Context, storeID primitive.ObjectID, input receiptInput) ([]models.ReceiptLine, float64, error) {
+	if len(input.Lines) == 0 {
+		return nil, 0, validationError{"El remito tiene que tener al menos una línea"}
+	}
+
+	lines := make([]models.ReceiptLine, 0, len(input.Lines))
+	total := 0.0
+	for i, in := range input.Lines {
+		productID, err := primitive.ObjectIDFromHex(in.ProductID)
+		if err != nil {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: producto inválido", i+1)}
+		}
+		if in.Quantity <= 0 {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: la cantidad debe ser mayor a cero", i+1)}
+		}
+		if in.UnitCost < 0 {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: el costo no puede ser negativo", i+1)}
+		}
+
+		var product models.Product
+		err = database.StockCollection.FindOne(ctx, bson.M{"_id": productID, "storeId": storeID}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: el producto no existe en la tienda", i+1)}
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if product.ArchivedAt != nil {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: %s está archivado", i+1, product.Name)}
+		}
+
+		measurement := in.Measurement
+		if measurement == "" {
+			measurement = product.Measurement
+		}
+		baseQuantity, ok := product.ToBase(in.Quantity, measurement)
+		if !ok {
+			return nil, 0, validationError{fmt.Sprintf("Línea %d: %s no tiene configurada la unidad %s", i+1, product.Name, measurement)}
+		}
+
+		lines = append(lines, models.ReceiptLine{
+			ProductID:    productID,
+			ProductName:  product.Name,
+			Quantity:     in.Quantity,
+			Measurement:  measurement,
+			UnitCost:     in.UnitCost,
+			BaseQuantity: baseQuantity,
+			BaseUnit:     product.Measurement,
+		})
+		total += in.Quantity * in.UnitCost
+	}
+	return lines, roundMoney(total), nil
+}
+
+// resolveReceiptSupplier returns the supplier id and name of the receipt. With a supplierId the
+// name is taken from the directory; otherwise the free text name is kept.
+func resolveReceiptSupplier(ctx context.Context, storeID primitive.ObjectID, input receiptInput) (*primitive.ObjectID, string, error) {
+	if input.SupplierID == "" {
+		return nil, strings.TrimSpace(input.Supplier), nil
+	}
+	supplierID, err := primitive.ObjectIDFromHex(input.SupplierID)
+	if err != nil {
+		return nil, "", validationError{"ID de proveedor inválido"}
+	}
+	supplier, err := findStoreSupplier(ctx, storeID, supplierID)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", validationError{"El proveedor no existe en la tienda"}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &supplier.ID, supplier.Name, nil
+}
+
+// respondValidationError answers validation errors with 400 and anything else with 500.
+func respondValidationError(c *gin.Context, err error, message string) {
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.msg})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// findStoreReceipt loads a receipt of the store; on failure it writes the error response.
+func findStoreReceipt(ctx context.Context, c *gin.Context, storeID primitive.ObjectID) (models.PurchaseReceipt, bool) {
+	var receipt models.PurchaseReceipt
+	receiptID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de remito inválido"})
+		return receipt, false
+	}
+	if err := database.PurchaseReceiptsCollection.FindOne(ctx, bson.M{"_id": receiptID, "storeId": storeID}).Decode(&receipt); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Remito no encontrado"})
+		return receipt, false
+	}
+	return receipt, true
+}
+
+// CreateReceiptHandler creates a draft purchase receipt. It does not touch the stock until confirmed.
+func CreateReceiptHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input receiptInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	supplierID, supplierName, err := resolveReceiptSupplier(ctx, storeID, input)
+	if err != nil {
+		respondValidationError(c, err, "Error al validar el proveedor")
+		return
+	}
+	lines, total, err := buildReceiptLines(ctx, storeID, input)
+	if err != nil {
+		respondValidationError(c, err, "Error al validar el remito")
+		return
+	}
+
+	now := time.Now()
+	date := now
+	if input.Date != nil {
+		date = *input.Date
+	}
+
+	receipt := models.PurchaseReceipt{
+		ID:         primitive.NewObjectID(),
+		StoreID:    storeID,
+		SupplierID: supplierID,
+		Supplier:   supplierName,
+		Date:       date,
+		Lines:      lines,
+		Total:      total,
+		Notes:      strings.TrimSpace(input.Notes),
+		Status:     models.ReceiptDraft,
+		CreatedBy:  actingUserFromContext(c),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if _, err := database.PurchaseReceiptsCollection.InsertOne(ctx, receipt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear remito"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, receipt)
+}
+
+// UpdateReceiptHandler replaces the content of a draft receipt
+func UpdateReceiptHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input receiptInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	receipt, ok := findStoreReceipt(ctx, c, storeID)
+	if !ok {
+		return
+	}
+	if receipt.Status != models.ReceiptDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se puede editar un remito en borrador"})
+		return
+	}
+
+	supplierID, supplierName, err := resolveReceiptSupplier(ctx, storeID, input)
+	if err != nil {
+		respondValidationError(c, err, "Error al validar el proveedor")
+		return
+	}
+	lines, total, err := buildReceiptLines(ctx, storeID, input)
+	if err != nil {
+		respondValidationError(c, err, "Error al validar el remito")
+		return
+	}
+
+	set := bson.M{
+		"supplier":  supplierName,
+		"lines":     lines,
+		"total":     total,
+		"notes":     strings.TrimSpace(input.Notes),
+		"updatedAt": time.Now(),
+	}
+	if input.Date != nil {
+		set["date"] = *input.Date
+	}
+	update := bson.M{"$set": set}
+	if supplierID != nil {
+		set["supplierId"] = *supplierID
+	} else {
+		update["$unset"] = bson.M{"supplierId": ""}
+	}
+
+	var updated models.PurchaseReceipt
+	err = database.PurchaseReceiptsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": receipt.ID, "status": models.ReceiptDraft},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se puede editar un remito en borrador"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar remito"})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// GetReceiptsHandler lists the receipts of the store, newest first. Filters: status, from, to (by receipt date).
+func GetReceiptsHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	page, limit := paginationParams(c)
+
+	filter := bson.M{"storeId": storeID}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+	date, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if date != nil {
+		filter["date"] = date
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.PurchaseReceiptsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar remitos"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.PurchaseReceiptsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener remitos"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	receipts := []models.PurchaseReceipt{}
+	if err := cursor.All(ctx, &receipts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar remitos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": receipts,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetReceiptHandler returns one receipt of the store
+func GetReceiptHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	receipt, ok := findStoreReceipt(ctx, c, storeID)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// receiptMovement builds the stock movement of a receipt line. A negative sign reverts it.
+func receiptMovement(receipt models.PurchaseReceipt, line models.ReceiptLine, sign float64, userID primitive.ObjectID, reason string) models.StockMovement {
+	m := models.StockMovement{
+		StoreID:       receipt.StoreID,
+		ProductID:     line.ProductID,
+		Type:          models.MovementPurchase,
+		Quantity:      sign * line.StockQuantity(),
+		Unit:          line.BaseUnit,
+		UserID:        userID,
+		Reason:        reason,
+		ReferenceType: receiptReferenceType,
+		ReferenceID:   &receipt.ID,
+	}
+	if line.BaseUnit != "" && line.Measurement != line.BaseUnit {
+		m.EnteredQuantity = sign * line.Quantity
+		m.EnteredUnit = line.Measurement
+	}
+	return m
+}
+
+// confirmedLine returns the line with the quantity that goes into the stock computed from
+// what was received, in the current base unit of the product.
+func confirmedLine(line models.ReceiptLine, product models.Product) (models.ReceiptLine, bool) {
+	base, ok := product.ToBase(line.Quantity, line.Measurement)
+	if !ok {
+		return line, false
+	}
+	line.BaseQuantity = base
+	line.BaseUnit = product.Measurement
+	return line, true
+}
+
+// cancelledLine returns the line with the quantity it added to the stock expressed in the
+// current base unit of the product, which may have changed since the receipt was confirmed.
+// It converts what was added (not what was received) so that cancelling reverts exactly that.
+func cancelledLine(line models.ReceiptLine, product models.Product) (models.ReceiptLine, bool) {
+	if line.BaseUnit == "" || line.BaseUnit == product.Measurement {
+		return line, true
+	}
+	base, ok := product.ToBase(line.StockQuantity(), line.BaseUnit)
+	if !ok {
+		return line, false
+	}
+	line.BaseQuantity = base
+	line.BaseUnit = product.Measurement
+	return line, true
+}
+
+// updateReceiptAlerts re-evaluates the low-stock alerts of the products of a receipt once
+// its transaction is committed.
+func updateReceiptAlerts(ctx context.Context, receipt models.PurchaseReceipt) {
+	for _, line := range receipt.Lines {
+		updateStockAlert(ctx, line.ProductID)
+	}
+}
+
+// ConfirmReceiptHandler confirms a draft: each line adds a purchase movement to the stock
+// and updates the product's last unit cost.
+func ConfirmReceiptHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	receipt, ok := findStoreReceipt(ctx, c, storeID)
+	if !ok {
+		return
+	}
+
+	userID := actingUserFromContext(c)
+	now := time.Now()
+
+	// Estado, stock, costos y precios del proveedor van en una transacción: o se aplica
+	// el remito entero o nada
+	var failedLine string
+	err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		// Pasamos a confirmado de forma atómica: un remito no puede sumar stock dos veces
+		err := database.PurchaseReceiptsCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": receipt.ID, "status": models.ReceiptDraft},
+			bson.M{"$set": bson.M{"status": models.ReceiptConfirmed, "confirmedBy": userID, "confirmedAt": now, "updatedAt": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&receipt)
+		if err != nil {
+			return err
+		}
+
+		// Las cantidades base se calcularon al cargar el borrador: desde entonces pudo
+		// cambiar la unidad o las conversiones del producto, así que se recalculan ahora
+		for i, line := range receipt.Lines {
+			failedLine = line.ProductName
+			var product models.Product
+			err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, line.ProductID)).Decode(&product)
+			if err == mongo.ErrNoDocuments {
+				return missingProductError(ctx, storeID, line.ProductID)
+			}
+			if err != nil {
+				return err
+			}
+			confirmed, ok := confirmedLine(line, product)
+			if !ok {
+				return validationError{fmt.Sprintf("%s ya no tiene configurada la unidad %s, editá el remito antes de confirmarlo", line.ProductName, line.Measurement)}
+			}
+			receipt.Lines[i] = confirmed
+		}
+		_, err = database.PurchaseReceiptsCollection.UpdateOne(ctx,
+			bson.M{"_id": receipt.ID},
+			bson.M{"$set": bson.M{"lines": receipt.Lines}},
+		)
+		if err != nil {
+			return err
+		}
+
+		reason := "Remito de compra"
+		if receipt.Supplier != "" {
+			reason += " - " + receipt.Supplier
+		}
+
+		for _, line := range receipt.Lines {
+			failedLine = line.ProductName
+			if _, err := applyStockMovement(ctx, receiptMovement(receipt, line, 1, userID, reason)); err != nil {
+				return err
+			}
+			_, err := database.StockCollection.UpdateOne(ctx,
+				bson.M{"_id": line.ProductID},
+				bson.M{"$set": bson.M{"lastUnitCost": roundMoney(line.BaseUnitCost()), "lastPurchaseAt": receipt.Date}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return recordSupplierPrices(ctx, receipt)
+	})
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusConflict, gin.H{"error": "Solo se puede confirmar un remito en borrador"})
+		return
+	}
+	if errors.Is(err, errProductNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("El producto %s ya no existe", failedLine)})
+		return
+	}
+	if errors.Is(err, errProductArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("El producto %s está archivado: restauralo para confirmar el remito", failedLine)})
+		return
+	}
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error confirmando remito %s: %v", receipt.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al confirmar remito"})
+		return
+	}
+
+	updateReceiptAlerts(ctx, receipt)
+	c.JSON(http.StatusOK, receipt)
+}
+
+// CancelReceiptHandler cancels a receipt. A draft is simply discarded; a confirmed receipt
+// needs PermPurchasesCancel and its stock is taken back out.
+func CancelReceiptHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	receipt, ok := findStoreReceipt(ctx, c, storeID)
+	if !ok {
+		return
+	}
+
+	switch receipt.Status {
+	case models.ReceiptCancelled:
+		c.JSON(http.StatusConflict, gin.H{"error": "El remito ya está anulado"})
+		return
+	case models.ReceiptConfirmed:
+		if !middleware.HasPermission(c, models.PermPurchasesCancel) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tenés permisos para anular un remito confirmado"})
+			return
+		}
+	}
+
+	userID := actingUserFromContext(c)
+	now := time.Now()
+
+	// El cambio de estado y la devolución del stock van juntos: si algo falla, el remito
+	// sigue como estaba y se puede volver a anular
+	var failedLine string
+	err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		err := database.PurchaseReceiptsCollection.FindOneAndUpdate(ctx,
+			bson.M{"_id": receipt.ID, "status": receipt.Status},
+			bson.M{"$set": bson.M{"status": models.ReceiptCancelled, "cancelledBy": userID, "cancelledAt": now, "updatedAt": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&receipt)
+		if err != nil || receipt.ConfirmedAt == nil {
+			return err
+		}
+
+		// Si ya había sumado stock, lo sacamos con movimientos inversos. La unidad base pudo
+		// cambiar desde la confirmación: se descuenta lo mismo que sumó, en la unidad de hoy
+		for _, line := range receipt.Lines {
+			failedLine = line.ProductName
+			var product models.Product
+			err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, line.ProductID)).Decode(&product)
+			if err == mongo.ErrNoDocuments {
+				return missingProductError(ctx, storeID, line.ProductID)
+			}
+			if err != nil {
+				return err
+			}
+			cancelled, ok := cancelledLine(line, product)
+			if !ok {
+				return validationError{fmt.Sprintf("%s ya no tiene configurada la unidad %s, volvé a configurarla para anular el remito", line.ProductName, line.BaseUnit)}
+			}
+			if _, err := applyStockMovement(ctx, receiptMovement(receipt, cancelled, -1, userID, "Anulación de remito")); err != nil {
+				return err
+			}
+		}
+		// Un remito anulado no cuenta para el historial de precios
+		_, err = database.SupplierPricesCollection.DeleteMany(ctx, bson.M{"receiptId": receipt.ID})
+		return err
+	})
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusConflict, gin.H{"error": "El remito cambió de estado, volvé a intentar"})
+		return
+	}
+	if errors.Is(err, errProductArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("El producto %s está archivado: restauralo para anular el remito", failedLine)})
+		return
+	}
+	var invalid validationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusConflict, gin.H{"error": invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Error anulando remito %s: %v", receipt.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al anular remito, volvé a intentar"})
+		return
+	}
+
+	if receipt.ConfirmedAt != nil {
+		updateReceiptAlerts(ctx, receipt)
+	}
+	c.JSON(http.StatusOK, receipt)
+}
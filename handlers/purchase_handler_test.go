@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"verdustock-auth/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCancelAfterBaseUnitChange(t *testing.T) {
+	product := models.Product{
+		Name:        "Tomate",
+		Measurement: models.Kilos,
+		Conversions: []models.UnitConversion{{Unit: models.Cajones, Factor: 18}},
+	}
+	receipt := models.PurchaseReceipt{}
+	received := models.ReceiptLine{ProductName: "Tomate", Quantity: 2, Measurement: models.Cajones, UnitCost: 21600}
+
+	// Confirmar: 2 cajones suman 36 kilos
+	line, ok := confirmedLine(received, product)
+	if !ok || line.BaseQuantity != 36 || line.BaseUnit != models.Kilos {
+		t.Fatalf("confirmedLine = %v %s, %v; want 36 KILOS", line.BaseQuantity, line.BaseUnit, ok)
+	}
+	product.Stock += receiptMovement(receipt, line, 1, primitive.NilObjectID, "").Quantity
+
+	// Cambiar la unidad base a cajones: el stock pasa a 2
+	product, _, err := rebaseProduct(product, models.Cajones)
+	if err != nil {
+		t.Fatalf("rebaseProduct: %v", err)
+	}
+
+	// Anular: tiene que descontar 2 cajones, no 36
+	line, ok = cancelledLine(line, product)
+	if !ok {
+		t.Fatal("cancelledLine: the old base unit should still be configured")
+	}
+	movement := receiptMovement(receipt, line, -1, primitive.NilObjectID, "")
+	if movement.Unit != models.Cajones || math.Abs(movement.Quantity+2) > stockEpsilon {
+		t.Errorf("cancel movement = %v %s, want -2 CAJONES", movement.Quantity, movement.Unit)
+	}
+	if stock := product.Stock + movement.Quantity; math.Abs(stock) > stockEpsilon {
+		t.Errorf("stock after cancel = %v, want 0", stock)
+	}
+}
+
+func TestCancelledLineWithoutOldUnit(t *testing.T) {
+	product := models.Product{Name: "Tomate", Measurement: models.Cajones}
+	line := models.ReceiptLine{Quantity: 2, Measurement: models.Cajones, BaseQuantity: 36, BaseUnit: models.Kilos}
+	if _, ok := cancelledLine(line, product); ok {
+		t.Error("cancelledLine should fail when the old base unit is no longer configured")
+	}
+
+	legacy := models.ReceiptLine{Quantity: 5, Measurement: models.Cajones}
+	if got, ok := cancelledLine(legacy, product); !ok || got.StockQuantity() != 5 {
+		t.Errorf("legacy line = %v, %v; want 5, true", got.StockQuantity(), ok)
+	}
+}
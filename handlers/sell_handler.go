@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 	"verdustock-auth/database"
+	"verdustock-auth/middleware"
 	"verdustock-auth/models"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +22,10 @@ func CreateSellHandler(c *gin.Context) {
 		return
 	}
 	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
 
 	var input struct {
 		Amount   float64         `json:"amount"`
@@ -35,7 +40,9 @@ func CreateSellHandler(c *gin.Context) {
 
 	sell := models.Sell{
 		ID:       primitive.NewObjectID(),
+		StoreID:  storeID,
 		UserID:   userID,
+		SoldBy:   actingUserFromContext(c),
 		Amount:   input.Amount,
 		Date:     time.Now(),
 		Type:     input.Type,
@@ -59,18 +66,16 @@ func CreateSellHandler(c *gin.Context) {
 
 // GetSellsHandler retrieves sells based on filters (open/closed)
 func GetSellsHandler(c *gin.Context) {
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
 	// Query params: status (open/closed), date (optional)
 	status := c.Query("status") // "open" or "closed"
 	dateParam := c.Query("date")
 
-	filter := bson.M{"userId": userID}
+	filter := bson.M{"storeId": storeID}
 
 	if status == "open" {
 		filter["isClosed"] = false
@@ -122,12 +127,10 @@ func UpdateSellHandler(c *gin.Context) {
 		return
 	}
 
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
 	var input struct {
 		Amount   *float64         `json:"amount"`
@@ -146,14 +149,15 @@ func UpdateSellHandler(c *gin.Context) {
 
 	// 1. Fetch existing sell
 	var existingSell models.Sell
-	err = database.SellsCollection.FindOne(ctx, bson.M{"_id": objID, "userId": userID}).Decode(&existingSell)
+	err = database.SellsCollection.FindOne(ctx, bson.M{"_id": objID, "storeId": storeID}).Decode(&existingSell)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Venta no encontrada"})
 		return
 	}
 
-	if existingSell.IsClosed {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No se puede modificar una venta de una caja cerrada"})
+	// Solo el dueño puede corregir ventas de una caja ya cerrada (queda en el historial)
+	if existingSell.IsClosed && !middleware.HasPermission(c, models.PermSellsEditClosed) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No se puede modificar una venta de una caja cerrada"})
 		return
 	}
 
@@ -232,21 +236,19 @@ func UpdateSellHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, updatedSell)
 }
 
-// CloseBoxHandler closes all open sells for the user (effectively closing the day)
+// CloseBoxHandler closes all open sells for the store (effectively closing the day)
 func CloseBoxHandler(c *gin.Context) {
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Update all open sells for this user to Closed = true
+	// Update all open sells for this store to Closed = true
 	filter := bson.M{
-		"userId":   userID,
+		"storeId":  storeID,
 		"isClosed": false,
 	}
 	update := bson.M{
@@ -259,6 +261,12 @@ func CloseBoxHandler(c *gin.Context) {
 		return
 	}
 
+	entry := requestAuditEntry(c, models.AuditBoxClosed)
+	entry.TargetType = "store"
+	entry.TargetID = storeID.Hex()
+	entry.Details = map[string]interface{}{"closedSells": result.ModifiedCount}
+	recordAudit(ctx, c, entry)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Caja cerrada exitosamente",
 		"closedDetails": result.ModifiedCount,
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/middleware"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sessionTTL           = 24 * time.Hour
+	rememberMeSessionTTL = 30 * 24 * time.Hour
+
+	// Dos pestañas que renuevan a la vez presentan el mismo token: no es un robo
+	refreshReuseGrace = 10 * time.Second
+)
+
+// startSession creates a new session (refresh token family) for the user,
+// issues both tokens and writes them as cookies.
+func startSession(ctx context.Context, c *gin.Context, user models.User, rememberMe bool, device string) error {
+	if user.StoreID.IsZero() {
+		return errors.New("el usuario no pertenece a ninguna tienda")
+	}
+
+	now := time.Now()
+	ttl := sessionTTL
+	if rememberMe {
+		ttl = rememberMeSessionTTL
+	}
+
+	session := models.Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     user.ID,
+		RememberMe: rememberMe,
+		Device:     describeDevice(device, c.Request.UserAgent()),
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if _, err := database.SessionsCollection.InsertOne(ctx, session); err != nil {
+		return err
+	}
+
+	refreshToken, _, err := issueRefreshToken(ctx, session)
+	if err != nil {
+		return err
+	}
+
+	return setSessionCookies(c, user, user, session, refreshToken)
+}
+
+// describeDevice returns the name sent by the client or, if empty, a rough guess from the user agent.
+func describeDevice(device, userAgent string) string {
+	if device = strings.TrimSpace(device); device != "" {
+		return device
+	}
+
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "ipad"):
+		return "iPad"
+	case strings.Contains(ua, "iphone"):
+		return "iPhone"
+	case strings.Contains(ua, "android"):
+		return "Android"
+	case strings.Contains(ua, "windows"):
+		return "Windows"
+	case strings.Contains(ua, "mac os"):
+		return "Mac"
+	case strings.Contains(ua, "linux"):
+		return "Linux"
+	}
+	return "Desconocido"
+}
+
+// issueRefreshToken generates a new opaque refresh token for the session and stores its hash.
+func issueRefreshToken(ctx context.Context, session models.Session) (string, primitive.ObjectID, error) {
+	token, err := middleware.GenerateRandomToken(32)
+	if err != nil {
+		return "", primitive.NilObjectID, err
+	}
+
+	doc := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: middleware.HashToken(token),
+		CreatedAt: time.Now(),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if _, err := database.RefreshTokensCollection.InsertOne(ctx, doc); err != nil {
+		return "", primitive.NilObjectID, err
+	}
+	return token, doc.ID, nil
+}
+
+// setSessionCookies writes a new access token and the session refresh token.
+// acting is the member operating the terminal (the holder itself unless switched with a PIN).
+func setSessionCookies(c *gin.Context, user, acting models.User, session models.Session, refreshToken string) error {
+	if err := setAccessCookie(c, user, acting, session); err != nil {
+		return err
+	}
+	middleware.SetRefreshCookie(c, refreshToken, time.Until(session.ExpiresAt))
+	return nil
+}
+
+// setAccessCookie issues an access token for the session with the acting member's role.
+func setAccessCookie(c *gin.Context, user, acting models.User, session models.Session) error {
+	// El rol y la tienda se releen de la base en cada refresh, así un cambio se aplica en minutos
+	accessToken, err := middleware.IssueAccessToken(user.ID.Hex(), session.ID.Hex(), user.StoreID.Hex(), acting.GetRole(), acting.ID.Hex())
+	if err != nil {
+		return err
+	}
+
+	middleware.SetAuthCookie(c, accessToken, middleware.AccessTokenTTL)
+	return nil
+}
+
+// actingUser returns the member switched in on the session if it can still operate,
+// otherwise it hands the terminal back to the session holder.
+func actingUser(ctx context.Context, holder models.User, session models.Session) models.User {
+	if session.ActingUserID == nil || *session.ActingUserID == holder.ID {
+		return holder
+	}
+
+	var acting models.User
+	err := database.UserCollection.FindOne(ctx, bson.M{"_id": *session.ActingUserID}).Decode(&acting)
+	if err != nil || !canSwitchTo(acting, holder.StoreID) {
+		// Lo deshabilitaron, le sacaron el PIN o cambió de tienda: vuelve el titular
+		_, _ = database.SessionsCollection.UpdateOne(ctx,
+			bson.M{"_id": session.ID},
+			bson.M{"$unset": bson.M{"actingUserId": ""}},
+		)
+		return holder
+	}
+	return acting
+}
+
+// revokeSession marks a session as revoked and invalidates all its pending refresh tokens.
+func revokeSession(ctx context.Context, sessionID primitive.ObjectID, reason string) error {
+	now := time.Now()
+	_, err := database.SessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": now, "revokedReason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.RefreshTokensCollection.UpdateMany(ctx,
+		bson.M{"sessionId": sessionID, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	)
+	return err
+}
+
+// revokeUserSessions revokes every active session of the user, optionally keeping one alive.
+func revokeUserSessions(ctx context.Context, userID primitive.ObjectID, except *primitive.ObjectID, reason string) (int, error) {
+	filter := bson.M{"userId": userID, "revokedAt": nil}
+	if except != nil {
+		filter["_id"] = bson.M{"$ne": *except}
+	}
+
+	cursor, err := database.SessionsCollection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return 0, err
+	}
+
+	for _, s := range sessions {
+		if err := revokeSession(ctx, s.ID, reason); err != nil {
+			return 0, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// readRefreshToken looks for the refresh token in its cookie and, for non-browser
+// clients, in the JSON body.
+func readRefreshToken(c *gin.Context) string {
+	if token, err := c.Cookie(middleware.RefreshCookieName); err == nil && token != "" {
+		return token
+	}
+
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.ShouldBindJSON(&body); err == nil {
+		return body.RefreshToken
+	}
+	return ""
+}
+
+// RefreshHandler rotates the refresh token: the presented token is consumed and a new
+// pair is issued. Presenting an already used token revokes the whole session, unless it was
+// rotated within the last seconds (another tab refreshing at the same time).
+func RefreshHandler(c *gin.Context) {
+	refreshToken := readRefreshToken(c)
+	if refreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token ausente"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	hash := middleware.HashToken(refreshToken)
+
+	// 1. Consumir el token de forma atómica (solo gana un request)
+	var current models.RefreshToken
+	err := database.RefreshTokensCollection.FindOneAndUpdate(ctx,
+		bson.M{"tokenHash": hash, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&current)
+
+	if err == mongo.ErrNoDocuments {
+		var reused models.RefreshToken
+		if database.RefreshTokensCollection.FindOne(ctx, bson.M{"tokenHash": hash}).Decode(&reused) == nil {
+			// 2a. Recién rotado por otro request: el sucesor ya viaja en la cookie de esa respuesta
+			if reused.UsedAt != nil && now.Sub(*reused.UsedAt) < refreshReuseGrace {
+				refreshWithinGrace(ctx, c, reused, now)
+				return
+			}
+
+			// 2b. Si no, alguien lo está reutilizando: matamos la familia
+			log.Printf("⚠️ Reutilización de refresh token detectada (sesión %s, usuario %s)", reused.SessionID.Hex(), reused.UserID.Hex())
+			if err := revokeSession(ctx, reused.SessionID, "refresh_token_reuse"); err != nil {
+				log.Printf("❌ Error revocando sesión %s: %v", reused.SessionID.Hex(), err)
+			}
+		}
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token inválido"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al renovar sesión"})
+		return
+	}
+
+	if current.ExpiresAt.Before(now) {
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión expirada"})
+		return
+	}
+
+	// 3. La sesión tiene que seguir viva
+	session, user, ok := refreshableSession(ctx, c, current.SessionID, now)
+	if !ok {
+		return
+	}
+
+	_, _ = database.SessionsCollection.UpdateOne(ctx,
+		bson.M{"_id": session.ID},
+		bson.M{"$set": bson.M{"lastSeenAt": now, "ip": c.ClientIP(), "userAgent": c.Request.UserAgent()}},
+	)
+
+	// 4. Emitir el nuevo par de tokens
+	newRefreshToken, newTokenID, err := issueRefreshToken(ctx, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al renovar sesión"})
+		return
+	}
+
+	_, _ = database.RefreshTokensCollection.UpdateOne(ctx,
+		bson.M{"_id": current.ID},
+		bson.M{"$set": bson.M{"replacedBy": newTokenID}},
+	)
+
+	if err := setSessionCookies(c, user, actingUser(ctx, user, session), session, newRefreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión renovada"})
+}
+
+// refreshableSession loads the session and its holder, answering 401 when either can no
+// longer be refreshed.
+func refreshableSession(ctx context.Context, c *gin.Context, sessionID primitive.ObjectID, now time.Time) (models.Session, models.User, bool) {
+	var session models.Session
+	var user models.User
+
+	err := database.SessionsCollection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	if err != nil || session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión expirada o revocada"})
+		return session, user, false
+	}
+
+	if err := database.UserCollection.FindOne(ctx, bson.M{"_id": session.UserID}).Decode(&user); err != nil {
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
+		return session, user, false
+	}
+	if user.Disabled {
+		_ = revokeSession(ctx, session.ID, "account_disabled")
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "La cuenta está deshabilitada"})
+		return session, user, false
+	}
+	return session, user, true
+}
+
+// refreshWithinGrace answers a refresh that lost the race against a concurrent one with the
+// same token. The winner's response already carries the successor refresh cookie, so here
+// only a new access token is issued and the refresh cookie is left untouched.
+func refreshWithinGrace(ctx context.Context, c *gin.Context, used models.RefreshToken, now time.Time) {
+	if used.ExpiresAt.Before(now) {
+		middleware.ClearAuthCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sesión expirada"})
+		return
+	}
+
+	session, user, ok := refreshableSession(ctx, c, used.SessionID, now)
+	if !ok {
+		return
+	}
+
+	if err := setAccessCookie(c, user, actingUser(ctx, user, session), session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al generar token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión renovada"})
+}
+
+// ListSessionsHandler returns the active sessions (devices) of the logged in user
+func ListSessionsHandler(c *gin.Context) {
+	userIDStr, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	currentSessionID := c.GetString("sessionId")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"userId":    userID,
+		"revokedAt": nil,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}})
+
+	cursor, err := database.SessionsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener sesiones"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []models.Session
+	if err := cursor.All(ctx, &sessions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar sesiones"})
+		return
+	}
+
+	result := []gin.H{}
+	for _, s := range sessions {
+		result = append(result, gin.H{
+			"id":         s.ID.Hex(),
+			"device":     s.Device,
+			"ip":         s.IP,
+			"userAgent":  s.UserAgent,
+			"createdAt":  s.CreatedAt,
+			"lastSeenAt": s.LastSeenAt,
+			"expiresAt":  s.ExpiresAt,
+			"current":    s.ID.Hex() == currentSessionID,
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RevokeSessionHandler revokes one session of the logged in user (e.g. a lost tablet)
+func RevokeSessionHandler(c *gin.Context) {
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de sesión inválido"})
+		return
+	}
+
+	userIDStr, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	count, err := database.SessionsCollection.CountDocuments(ctx, bson.M{"_id": sessionID, "userId": userID, "revokedAt": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al buscar sesión"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sesión no encontrada"})
+		return
+	}
+
+	if err := revokeSession(ctx, sessionID, "revoked_by_user"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al revocar sesión"})
+		return
+	}
+
+	// Si cerró la sesión actual, limpiamos también sus cookies
+	if sessionID.Hex() == c.GetString("sessionId") {
+		middleware.ClearAuthCookies(c)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Sesión revocada correctamente"})
+}
+
+// LogoutAllHandler revokes every session of the logged in user, including the current one
+func LogoutAllHandler(c *gin.Context) {
+	userIDStr, exists := c.Get("userId")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+		return
+	}
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	revoked, err := revokeUserSessions(ctx, userID, nil, "logout_all")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al cerrar sesiones"})
+		return
+	}
+
+	middleware.ClearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Se cerraron todas las sesiones",
+		"revoked": revoked,
+	})
+}
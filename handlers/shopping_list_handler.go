@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultUsageDays = 14 // Ventana para calcular el consumo diario
+	maxUsageDays     = 90
+	defaultCoverDays = 2 // Días que tiene que cubrir la compra (hasta la próxima ida al mercado)
+	maxCoverDays     = 30
+)
+
+// Argentina no tiene horario de verano: el día de compras se cuenta en UTC-3
+var shoppingDayZone = time.FixedZone("ART", -3*60*60)
+
+// currentShoppingDay is today's date in Argentina, as stored in Product.LoadedOn.
+func currentShoppingDay() string {
+	return time.Now().In(shoppingDayZone).Format("2006-01-02")
+}
+
+// boundedIntQuery reads a positive int query param, using def when missing and capping at max.
+func boundedIntQuery(c *gin.Context, name string, def, max int) (int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Parámetro " + name + " inválido"})
+		return 0, false
+	}
+	if n > max {
+		n = max
+	}
+	return n, true
+}
+
+// roundUpPurchase rounds a quantity up to what can actually be bought: whole cajones,
+// bolsas or unidades, and half kilos.
+func roundUpPurchase(quantity float64, unit models.Measurement) float64 {
+	if unit == models.Kilos {
+		return math.Ceil(quantity*2) / 2
+	}
+	return math.Ceil(quantity)
+}
+
+// shoppingItem is a product of the shopping list.
+type shoppingItem struct {
+	ProductID     primitive.ObjectID `json:"productId"`
+	Name          string             `json:"name"`
+	Type          models.ProductType `json:"type,omitempty"`
+	Stock         float64            `json:"stock"`
+	MinStock      float64            `json:"minStock,omitempty"`
+	Unit          models.Measurement `json:"unit"`       // Unidad base de Stock, DailyUsage y SuggestedBase
+	DailyUsage    float64            `json:"dailyUsage"` // Promedio que salió por día en la ventana (ventas, merma, conteos)
+	HasUsage      bool               `json:"hasUsage"`   // false = sin salidas en la ventana: la sugerencia solo cubre el mínimo
+	SuggestedBase float64            `json:"suggestedBase"`
+	Suggested     float64            `json:"suggested"` // En PurchaseUnit, redondeado hacia arriba
+	PurchaseUnit  models.Measurement `json:"purchaseUnit"`
+	EstimatedCost float64            `json:"estimatedCost,omitempty"` // Según el último costo de compra
+	Loaded        bool               `json:"loaded"`
+}
+
+// GetShoppingListHandler builds the shopping list for the market: for each product it
+// suggests how much to buy (in its purchase unit) so that the stock covers coverDays of
+// usage at the pace of the last days, plus the minimum stock. Usage counts every outflow
+// (sales, waste, transfers and counts below the expected stock), since most stores never
+// record sales one by one.
+// Query: days (usage window), coverDays, all=true to include products with nothing to buy.
+func GetShoppingListHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	days, ok := boundedIntQuery(c, "days", defaultUsageDays, maxUsageDays)
+	if !ok {
+		return
+	}
+	coverDays, ok := boundedIntQuery(c, "coverDays", defaultCoverDays, maxCoverDays)
+	if !ok {
+		return
+	}
+	includeAll := c.Query("all") == "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cursor, err := database.StockCollection.Find(ctx, bson.M{"storeId": storeID, "archivedAt": nil})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock"})
+		return
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al decodificar productos"})
+		return
+	}
+
+	// El consumo son todas las salidas de la ventana. Las compras negativas son remitos
+	// anulados: no es mercadería que se haya ido
+	since := time.Now().AddDate(0, 0, -days)
+	usageCursor, err := database.StockMovementsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"storeId":   storeID,
+			"type":      bson.M{"$ne": models.MovementPurchase},
+			"quantity":  bson.M{"$lt": 0},
+			"createdAt": bson.M{"$gte": since},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$productId", "used": bson.M{"$sum": bson.M{"$multiply": bson.A{"$quantity", -1}}}}}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular el consumo"})
+		return
+	}
+	var usage []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Used      float64            `bson:"used"`
+	}
+	if err := usageCursor.All(ctx, &usage); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar el consumo"})
+		return
+	}
+	used := make(map[primitive.ObjectID]float64, len(usage))
+	for _, u := range usage {
+		used[u.ProductID] = u.Used
+	}
+	today := currentShoppingDay()
+
+	items := []shoppingItem{}
+	totalCost := 0.0
+	usageData := false
+	for _, product := range products {
+		daily := used[product.ID] / float64(days)
+		usageData = usageData || daily > 0
+		loaded := product.LoadedOnDay(today)
+		need := math.Max(0, daily*float64(coverDays)+product.MinStock-product.Stock)
+
+		unit := product.PurchaseUnit
+		factor, ok := product.UnitFactor(unit)
+		if !ok || unit == "" {
+			unit, factor = product.Measurement, 1
+		}
+		suggested := roundUpPurchase(need/factor, unit)
+
+		if suggested == 0 && !loaded && !includeAll {
+			continue
+		}
+
+		item := shoppingItem{
+			ProductID:     product.ID,
+			Name:          product.Name,
+			Type:          product.Type,
+			Stock:         product.Stock,
+			MinStock:      product.MinStock,
+			Unit:          product.Measurement,
+			DailyUsage:    math.Round(daily*100) / 100,
+			HasUsage:      used[product.ID] > 0,
+			SuggestedBase: suggested * factor,
+			Suggested:     suggested,
+			PurchaseUnit:  unit,
+			Loaded:        loaded,
+		}
+		if cost := productUnitCost(product); cost > 0 {
+			item.EstimatedCost = roundMoney(item.SuggestedBase * cost)
+			totalCost += item.EstimatedCost
+		}
+		items = append(items, item)
+	}
+
+	// Ordenado por tipo y nombre, como se recorre el mercado
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Type != items[j].Type {
+			return items[i].Type < items[j].Type
+		}
+		return items[i].Name < items[j].Name
+	})
+
+	response := gin.H{
+		"shoppingDay":   today,
+		"days":          days,
+		"coverDays":     coverDays,
+		"usageData":     usageData,
+		"items":         items,
+		"estimatedCost": roundMoney(totalCost),
+	}
+	if !usageData {
+		response["warning"] = "No hay salidas registradas en los últimos días: la lista solo repone hasta el stock mínimo. Registrá ventas, mermas o conteos para que sugiera según el consumo"
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Estados en los que una alerta sigue activa
+var activeAlertStatuses = bson.A{models.AlertOpen, models.AlertAcknowledged}
+
+// evaluateStockAlert opens, refreshes or resolves the low-stock alert of the product after
+// its stock or minimum changed. It always works on the stored product, and evaluates again
+// if the product changed meanwhile, so calls that finish out of order still end up right.
+// Archived products never keep an alert open.
+func evaluateStockAlert(ctx context.Context, productID primitive.ObjectID) error {
+	for i := 0; i < countRetries; i++ {
+		var product models.Product
+		if err := database.StockCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+			return err
+		}
+
+		err := applyStockAlert(ctx, product)
+		if mongo.IsDuplicateKeyError(err) {
+			continue // Otro request abrió la alerta recién: la actualizamos en la próxima vuelta
+		}
+		if err != nil {
+			return err
+		}
+
+		// Si el stock o el mínimo cambiaron mientras tanto, lo que escribimos puede estar viejo
+		var latest models.Product
+		if err := database.StockCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&latest); err != nil {
+			return err
+		}
+		if latest.Stock == product.Stock && latest.MinStock == product.MinStock && (latest.ArchivedAt == nil) == (product.ArchivedAt == nil) {
+			return nil
+		}
+	}
+	return errStockConflict
+}
+
+// applyStockAlert writes the alert state that corresponds to the product snapshot.
+func applyStockAlert(ctx context.Context, product models.Product) error {
+	now := time.Now()
+	active := bson.M{"productId": product.ID, "active": true}
+
+	if product.ArchivedAt != nil || product.MinStock <= 0 || product.Stock > product.MinStock {
+		_, err := database.StockAlertsCollection.UpdateMany(ctx, active, bson.M{
+			"$set": bson.M{
+				"status":     models.AlertResolved,
+				"stock":      product.Stock,
+				"updatedAt":  now,
+				"resolvedAt": now,
+			},
+			"$unset": bson.M{"active": ""},
+		})
+		return err
+	}
+
+	// Una sola alerta activa por producto: si ya hay una, solo se actualiza el stock
+	_, err := database.StockAlertsCollection.UpdateOne(ctx, active,
+		bson.M{
+			"$set": bson.M{
+				"productName": product.Name,
+				"stock":       product.Stock,
+				"minStock":    product.MinStock,
+				"unit":        product.Measurement,
+				"updatedAt":   now,
+			},
+			"$setOnInsert": bson.M{
+				"storeId":   product.StoreID,
+				"status":    models.AlertOpen,
+				"createdAt": now,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetStockAlertsHandler returns the alerts of the store, newest first. By default only the
+// active ones (open and acknowledged); status=resolved or status=all for the rest.
+// The counts are for the frontend badge.
+func GetStockAlertsHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"storeId": storeID}
+	switch status := models.AlertStatus(c.Query("status")); status {
+	case "":
+		filter["status"] = bson.M{"$in": activeAlertStatuses}
+	case "all":
+	case models.AlertOpen, models.AlertAcknowledged, models.AlertResolved:
+		filter["status"] = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Estado inválido"})
+		return
+	}
+	page, limit := paginationParams(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.StockAlertsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar alertas"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updatedAt", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.StockAlertsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener alertas"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	alerts := []models.StockAlert{}
+	if err := cursor.All(ctx, &alerts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar alertas"})
+		return
+	}
+
+	open, err := database.StockAlertsCollection.CountDocuments(ctx, bson.M{"storeId": storeID, "status": models.AlertOpen})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar alertas"})
+		return
+	}
+	acknowledged, err := database.StockAlertsCollection.CountDocuments(ctx, bson.M{"storeId": storeID, "status": models.AlertAcknowledged})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar alertas"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":        alerts,
+		"total":        total,
+		"page":         page,
+		"limit":        limit,
+		"open":         open,
+		"acknowledged": acknowledged,
+	})
+}
+
+// AcknowledgeStockAlertHandler marks an open alert as seen. It stays active until the stock
+// goes back above the minimum.
+func AcknowledgeStockAlertHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	alertID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de alerta inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID := actingUserFromContext(c)
+	now := time.Now()
+
+	var alert models.StockAlert
+	err = database.StockAlertsCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": alertID, "storeId": storeID, "status": models.AlertOpen},
+		bson.M{"$set": bson.M{
+			"status":         models.AlertAcknowledged,
+			"acknowledgedBy": userID,
+			"acknowledgedAt": now,
+			"updatedAt":      now,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&alert)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alerta no encontrada o ya no está abierta"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar alerta"})
+		return
+	}
+
+	c.JSON(http.StatusOK, alert)
+}
@@ -2,16 +2,26 @@ package handlers
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 	"verdustock-auth/database"
+	"verdustock-auth/middleware"
 	"verdustock-auth/models"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Movimiento del stock con el que se creó el producto: es el único que no impide borrarlo
+const initialStockReferenceType = "initial_stock"
+
+var errProductHasHistory = errors.New("el producto tiene movimientos o remitos")
+
 // InitializeCatalog checks if the catalog is empty and populates it if so
 func InitializeCatalog() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -26,7 +36,7 @@ func InitializeCatalog() error {
 		defaultProducts := models.GetDefaultProducts()
 		var documents []interface{}
 		for _, p := range defaultProducts {
-			// Catalog items don't need UserID
+			// Catalog items don't need StoreID
 			p.ID = primitive.NewObjectID()
 			documents = append(documents, p)
 		}
@@ -41,26 +51,23 @@ func InitializeCatalog() error {
 	return nil
 }
 
-// GetStockHandler returns the stock for the logged in user
+// GetStockHandler returns the stock of the store of the logged in user
 func GetStockHandler(c *gin.Context) {
-	// Get UserID from context (set by middleware)
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
-		return
-	}
-
-	userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+	// Get StoreID from context (set by middleware)
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Find products for this user
-	cursor, err := database.StockCollection.Find(ctx, bson.M{"userId": userID})
+	// Find products for this store (archived ones only if requested)
+	filter := bson.M{"storeId": storeID}
+	if c.Query("includeArchived") != "true" {
+		filter["archivedAt"] = nil
+	}
+	cursor, err := database.StockCollection.Find(ctx, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock"})
 		return
@@ -71,9 +78,25 @@ func GetStockHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al decodificar productos"})
 		return
 	}
+	// Los tildes de días anteriores ya no cuentan
+	today := currentShoppingDay()
+	for i := range products {
+		products[i].Loaded = products[i].LoadedOnDay(today)
+	}
 
-	// If user has no products, initialize them from the CATALOG collection
-	if len(products) == 0 {
+	// Una tienda con todo archivado no es una tienda nueva: el catálogo solo se copia si no hay ningún producto
+	storeHasProducts := len(products) > 0
+	if !storeHasProducts {
+		count, err := database.StockCollection.CountDocuments(ctx, bson.M{"storeId": storeID}, options.Count().SetLimit(1))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener stock"})
+			return
+		}
+		storeHasProducts = count > 0
+	}
+
+	// If the store has no products, initialize them from the CATALOG collection
+	if !storeHasProducts {
 		// Fetch from Catalog
 		catalogCursor, err := database.CatalogCollection.Find(ctx, bson.M{})
 		if err != nil {
@@ -92,7 +115,7 @@ func GetStockHandler(c *gin.Context) {
 
 		for _, p := range catalogItems {
 			p.ID = primitive.NewObjectID()
-			p.UserID = userID
+			p.StoreID = storeID
 			p.Stock = 0 // Ensure starts at 0
 			// Append both to the slice of documents to insert and the slice to return
 			documents = append(documents, p)
@@ -111,7 +134,8 @@ func GetStockHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, products)
 }
 
-// UpdateProductHandler updates stock and measurement for a specific product
+// UpdateProductHandler updates stock, measurement and prices for a specific product.
+// Price changes need PermPricesWrite and are recorded in the price log.
 func UpdateProductHandler(c *gin.Context) {
 	idStr := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(idStr)
@@ -120,17 +144,22 @@ func UpdateProductHandler(c *gin.Context) {
 		return
 	}
 
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
 	var input struct {
-		Stock       *float64            `json:"stock"`
-		Measurement *models.Measurement `json:"measurement"`
-		Loaded      *bool               `json:"loaded"`
+		Stock        *float64            `json:"stock"`       // Stock contado: la diferencia se registra como ajuste
+		StockUnit    models.Measurement  `json:"stockUnit"`   // Unidad del stock contado (por defecto la base)
+		Measurement  *models.Measurement `json:"measurement"` // Cambia la unidad base convirtiendo stock y precios
+		Loaded       *bool               `json:"loaded"`
+		MinStock     *float64            `json:"minStock"`     // En la unidad base; 0 desactiva la alerta
+		PurchaseUnit *models.Measurement `json:"purchaseUnit"` // "" vuelve a la unidad base
+		Reason       string              `json:"reason"`
+		CostPrice    *float64            `json:"costPrice"`
+		SalePrice    *float64            `json:"salePrice"`
+		ListPrices   map[string]*float64 `json:"listPrices"` // null borra el precio de esa lista
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -138,71 +167,420 @@ func UpdateProductHandler(c *gin.Context) {
 		return
 	}
 
-	update := bson.M{}
-	if input.Stock != nil {
-		update["stock"] = *input.Stock
-	}
-	if input.Measurement != nil {
-		update["measurement"] = *input.Measurement
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	changesPrices := input.CostPrice != nil || input.SalePrice != nil || len(input.ListPrices) > 0
+	if changesPrices {
+		if !middleware.HasPermission(c, models.PermPricesWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tenés permisos para cambiar precios"})
+			return
+		}
+		if err := validatePrices(ctx, storeID, input.CostPrice, input.SalePrice, input.ListPrices); err != nil {
+			respondValidationError(c, err, "Error al validar precios")
+			return
+		}
 	}
+
+	update := bson.M{}
+	unset := bson.M{}
 	if input.Loaded != nil {
 		update["loaded"] = *input.Loaded
+		if *input.Loaded {
+			update["loadedOn"] = currentShoppingDay()
+		} else {
+			unset["loadedOn"] = ""
+		}
+	}
+	if input.PurchaseUnit != nil {
+		if *input.PurchaseUnit == "" {
+			unset["purchaseUnit"] = ""
+		} else {
+			update["purchaseUnit"] = *input.PurchaseUnit
+		}
+	}
+	if input.MinStock != nil {
+		if *input.MinStock < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "El stock mínimo no puede ser negativo"})
+			return
+		}
+		update["minStock"] = *input.MinStock
+	}
+	if input.CostPrice != nil {
+		update["costPrice"] = *input.CostPrice
+	}
+	if input.SalePrice != nil {
+		update["salePrice"] = *input.SalePrice
+	}
+	for code, price := range input.ListPrices {
+		if price == nil {
+			unset["listPrices."+code] = ""
+		} else {
+			update["listPrices."+code] = *price
+		}
 	}
 
-	if len(update) == 0 {
+	if len(update) == 0 && len(unset) == 0 && input.Stock == nil && input.Measurement == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No se enviaron datos para actualizar"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Todo el pedido se aplica junto: si algo no valida con el producto ya convertido a la
+	// unidad nueva, no queda nada a medio guardar
+	userID := actingUserFromContext(c)
+	err = database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		// Primero la unidad: los precios y el stock que vengan en el mismo pedido ya son de la unidad nueva
+		if input.Measurement != nil {
+			if err := changeBaseUnit(ctx, storeID, objID, *input.Measurement, userID); err != nil {
+				return err
+			}
+		}
 
-	result, err := database.StockCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": objID, "userId": userID},
-		bson.M{"$set": update},
-	)
+		var product models.Product
+		err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, objID)).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			return missingProductError(ctx, storeID, objID)
+		}
+		if err != nil {
+			return err
+		}
+		if input.PurchaseUnit != nil && *input.PurchaseUnit != "" {
+			if _, ok := product.UnitFactor(*input.PurchaseUnit); !ok {
+				return validationError{"El producto no tiene configurada la unidad " + string(*input.PurchaseUnit)}
+			}
+		}
+		var counted float64
+		if input.Stock != nil {
+			var ok bool
+			if counted, ok = product.ToBase(*input.Stock, input.StockUnit); !ok {
+				return validationError{"El producto no tiene configurada la unidad " + string(input.StockUnit)}
+			}
+		}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar producto"})
+		if len(update) > 0 || len(unset) > 0 {
+			changes := bson.M{}
+			if len(update) > 0 {
+				changes["$set"] = update
+			}
+			if len(unset) > 0 {
+				changes["$unset"] = unset
+			}
+			if _, err := database.StockCollection.UpdateOne(ctx, activeProductFilter(storeID, objID), changes); err != nil {
+				return err
+			}
+			if changesPrices {
+				after := withPrices(product, input.CostPrice, input.SalePrice, input.ListPrices)
+				recordPriceChanges(ctx, priceChanges(product, after), userID, models.PriceSourceManual, nil)
+			}
+		}
+
+		// El stock nunca se pisa: el valor contado se registra como un movimiento de ajuste
+		if input.Stock != nil {
+			_, err := applyStockCount(ctx, storeID, objID, counted, userID, input.Reason)
+			return err
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	case errors.Is(err, errProductArchived):
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto está archivado: restauralo para modificarlo"})
+		return
+	case errors.Is(err, errStockConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "El stock cambió mientras se guardaba, volvé a intentar"})
+		return
+	case err != nil:
+		respondValidationError(c, err, "Error al actualizar producto")
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece al usuario"})
-		return
+	// La unidad, el mínimo o el conteo pueden abrir o cerrar la alerta
+	if input.Measurement != nil || input.MinStock != nil || input.Stock != nil {
+		updateStockAlert(ctx, objID)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Producto actualizado correctamente"})
 }
 
+// withPrices returns a copy of the product with the given price changes applied.
+func withPrices(product models.Product, cost, sale *float64, listPrices map[string]*float64) models.Product {
+	if cost != nil {
+		product.CostPrice = *cost
+	}
+	if sale != nil {
+		product.SalePrice = *sale
+	}
+	lists := make(map[string]float64, len(product.ListPrices))
+	for code, price := range product.ListPrices {
+		lists[code] = price
+	}
+	for code, price := range listPrices {
+		if price == nil {
+			delete(lists, code)
+		} else {
+			lists[code] = *price
+		}
+	}
+	product.ListPrices = lists
+	return product
+}
+
 // CreateProductHandler allows creating a new product
 func CreateProductHandler(c *gin.Context) {
-	userIDStr, exists := c.Get("userId")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no identificado"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
-	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 
-	var product models.Product
-	if err := c.ShouldBindJSON(&product); err != nil {
+	// Solo lo que carga el usuario: proveedores y último costo salen de los remitos
+	var input struct {
+		Name         string                  `json:"name"`
+		Stock        float64                 `json:"stock"`
+		Type         models.ProductType      `json:"type"`
+		Measurement  models.Measurement      `json:"measurement"`
+		MinStock     float64                 `json:"minStock"`
+		Conversions  []models.UnitConversion `json:"conversions"`
+		PurchaseUnit models.Measurement      `json:"purchaseUnit"`
+		CostPrice    float64                 `json:"costPrice"`
+		SalePrice    float64                 `json:"salePrice"`
+		ListPrices   map[string]float64      `json:"listPrices"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
 		return
 	}
 
-	// Assign current user ID and new ObjectID
-	product.UserID = userID
-	product.ID = primitive.NewObjectID()
+	product := models.Product{
+		ID:           primitive.NewObjectID(),
+		StoreID:      storeID,
+		Name:         input.Name,
+		Stock:        input.Stock,
+		Type:         input.Type,
+		Measurement:  input.Measurement,
+		MinStock:     input.MinStock,
+		Conversions:  input.Conversions,
+		PurchaseUnit: input.PurchaseUnit,
+		CostPrice:    input.CostPrice,
+		SalePrice:    input.SalePrice,
+		ListPrices:   input.ListPrices,
+	}
+
+	if !product.Measurement.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unidad inválida"})
+		return
+	}
+	if product.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El stock inicial no puede ser negativo"})
+		return
+	}
+	if product.MinStock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El stock mínimo no puede ser negativo"})
+		return
+	}
+	if err := validateConversions(product.Measurement, product.Conversions); err != nil {
+		respondValidationError(c, err, "Error al validar conversiones")
+		return
+	}
+	if _, ok := product.UnitFactor(product.PurchaseUnit); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El producto no tiene configurada la unidad " + string(product.PurchaseUnit)})
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := database.StockCollection.InsertOne(ctx, product)
+	hasPrices := product.CostPrice != 0 || product.SalePrice != 0 || len(product.ListPrices) > 0
+	if hasPrices {
+		if !middleware.HasPermission(c, models.PermPricesWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No tenés permisos para cargar precios"})
+			return
+		}
+		listPrices := make(map[string]*float64, len(product.ListPrices))
+		for code := range product.ListPrices {
+			price := product.ListPrices[code]
+			listPrices[code] = &price
+		}
+		if err := validatePrices(ctx, storeID, &product.CostPrice, &product.SalePrice, listPrices); err != nil {
+			respondValidationError(c, err, "Error al validar precios")
+			return
+		}
+	}
+
+	// El stock con el que nace el producto también queda en el historial, en la misma transacción
+	err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		if _, err := database.StockCollection.InsertOne(ctx, product); err != nil {
+			return err
+		}
+		if product.Stock == 0 {
+			return nil
+		}
+		movement := models.StockMovement{
+			StoreID:   storeID,
+			ProductID: product.ID,
+			Type:      models.MovementAdjustment,
+			Quantity:  product.Stock,
+			UserID:    actingUserFromContext(c),
+			Reason:    "Stock inicial",
+			// Marca el movimiento que no impide borrar el producto
+			ReferenceType: initialStockReferenceType,
+		}
+		return insertStockMovement(ctx, &movement, product)
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear producto"})
 		return
 	}
+	updateStockAlert(ctx, product.ID)
+
+	// Los precios con los que nace también quedan en el historial
+	if hasPrices {
+		recordPriceChanges(ctx, priceChanges(models.Product{}, product), actingUserFromContext(c), models.PriceSourceManual, nil)
+	}
 
 	c.JSON(http.StatusCreated, product)
 }
+
+// ArchiveProductHandler hides a product from the stock without losing its history
+func ArchiveProductHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err = database.StockCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": productID, "storeId": storeID, "archivedAt": nil},
+		bson.M{"$set": bson.M{"archivedAt": time.Now(), "archivedBy": actingUserFromContext(c), "loaded": false}, "$unset": bson.M{"loadedOn": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o ya archivado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al archivar producto"})
+		return
+	}
+
+	updateStockAlert(ctx, productID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Producto archivado"})
+}
+
+// RestoreProductHandler brings an archived product back to the stock
+func RestoreProductHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err = database.StockCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": productID, "storeId": storeID, "archivedAt": bson.M{"$ne": nil}},
+		bson.M{"$unset": bson.M{"archivedAt": "", "archivedBy": ""}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no está archivado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al restaurar producto"})
+		return
+	}
+
+	updateStockAlert(ctx, productID)
+
+	c.JSON(http.StatusOK, product)
+}
+
+// DeleteProductHandler removes a product for good. Only products without history can be
+// deleted (at most the movement of their initial stock); the rest have to be archived.
+func DeleteProductHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	references := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+	}{
+		{database.StockMovementsCollection, bson.M{"productId": productID, "referenceType": bson.M{"$ne": initialStockReferenceType}}},
+		{database.PurchaseReceiptsCollection, bson.M{"storeId": storeID, "lines.productId": productID}},
+	}
+
+	// Primero se borra el producto y después se revisa el historial, todo en una transacción:
+	// un movimiento que llegue en el medio choca con el borrado y uno de los dos se reintenta
+	err = database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		result, err := database.StockCollection.DeleteOne(ctx, bson.M{"_id": productID, "storeId": storeID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return errProductNotFound
+		}
+
+		for _, ref := range references {
+			count, err := ref.collection.CountDocuments(ctx, ref.filter, options.Count().SetLimit(1))
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return errProductHasHistory
+			}
+		}
+
+		// Lo que solo tenía sentido con el producto
+		for _, collection := range []*mongo.Collection{
+			database.StockMovementsCollection,
+			database.PriceChangesCollection,
+			database.StockAlertsCollection,
+		} {
+			if _, err := collection.DeleteMany(ctx, bson.M{"productId": productID}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errProductNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	case errors.Is(err, errProductHasHistory):
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto tiene movimientos o remitos: archivalo en lugar de borrarlo"})
+		return
+	case err != nil:
+		log.Printf("❌ Error eliminando el producto %s: %v", productID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar producto"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Producto eliminado"})
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Reintentos de un ajuste por conteo si otro movimiento cambió el stock en el medio
+	countRetries = 3
+
+	// Diferencia de stock despreciable (las sumas de float64 no son exactas)
+	stockEpsilon = 1e-9
+)
+
+var (
+	errProductNotFound   = errors.New("producto no encontrado")
+	errProductArchived   = errors.New("el producto está archivado")
+	errStockConflict     = errors.New("el stock cambió mientras se registraba el conteo")
+	errInsufficientStock = errors.New("no hay stock suficiente")
+)
+
+// activeProductFilter matches a product of the store that is not archived: archived
+// products keep their history but take no new writes.
+func activeProductFilter(storeID, productID primitive.ObjectID) bson.M {
+	return bson.M{"_id": productID, "storeId": storeID, "archivedAt": nil}
+}
+
+// missingProductError explains why activeProductFilter matched nothing: the product is
+// archived or it does not exist in the store.
+func missingProductError(ctx context.Context, storeID, productID primitive.ObjectID) error {
+	count, err := database.StockCollection.CountDocuments(ctx, bson.M{"_id": productID, "storeId": storeID})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errProductArchived
+	}
+	return errProductNotFound
+}
+
+// guardedOutflow reports whether outflows of the type can't take the stock below zero.
+func guardedOutflow(t models.MovementType) bool {
+	switch t {
+	case models.MovementWaste, models.MovementSale, models.MovementTransfer:
+		return true
+	}
+	return false
+}
+
+// applyStockMovement adds the (signed) quantity to the product stock and records the
+// movement in the same transaction. Every stock change must go through here (or applyStockCount).
+func applyStockMovement(ctx context.Context, m models.StockMovement) (models.StockMovement, error) {
+	filter := activeProductFilter(m.StoreID, m.ProductID)
+	// No puede salir lo que no hay (merma, venta o traspaso): primero hay que ajustar el stock
+	// con un conteo. Los ajustes y las anulaciones de remitos corrigen el stock y no se limitan
+	guarded := m.Quantity < 0 && guardedOutflow(m.Type)
+	if guarded {
+		filter["stock"] = bson.M{"$gte": -m.Quantity}
+	}
+
+	var product models.Product
+	err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+		err := database.StockCollection.FindOneAndUpdate(ctx,
+			filter,
+			bson.M{"$inc": bson.M{"stock": m.Quantity}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&product)
+		if err == mongo.ErrNoDocuments && guarded {
+			count, err := database.StockCollection.CountDocuments(ctx, activeProductFilter(m.StoreID, m.ProductID))
+			if err == nil && count > 0 {
+				return errInsufficientStock
+			}
+		}
+		if err == mongo.ErrNoDocuments {
+			return missingProductError(ctx, m.StoreID, m.ProductID)
+		}
+		if err != nil {
+			return err
+		}
+		return insertStockMovement(ctx, &m, product)
+	})
+	if err != nil {
+		return m, err
+	}
+
+	updateStockAlert(ctx, product.ID)
+	return m, nil
+}
+
+// applyStockCount sets the stock to a counted value by recording the difference as an
+// adjustment. It returns nil when the count matches the current stock.
+func applyStockCount(ctx context.Context, storeID, productID primitive.ObjectID, counted float64, userID primitive.ObjectID, reason string) (*models.StockMovement, error) {
+	if strings.TrimSpace(reason) == "" {
+		reason = "Conteo"
+	}
+
+	for i := 0; i < countRetries; i++ {
+		var current models.Product
+		err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, productID)).Decode(&current)
+		if err == mongo.ErrNoDocuments {
+			return nil, missingProductError(ctx, storeID, productID)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		delta := counted - current.Stock
+		if delta == 0 {
+			return nil, nil
+		}
+
+		m := models.StockMovement{
+			StoreID:   storeID,
+			ProductID: productID,
+			Type:      models.MovementAdjustment,
+			Quantity:  delta,
+			UserID:    userID,
+			Reason:    reason,
+		}
+		var product models.Product
+		err = database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+			// Solo aplicamos la diferencia si nadie movió el stock desde que lo leímos
+			err := database.StockCollection.FindOneAndUpdate(ctx,
+				bson.M{"_id": productID, "storeId": storeID, "archivedAt": nil, "stock": current.Stock},
+				bson.M{"$set": bson.M{"stock": counted}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After),
+			).Decode(&product)
+			if err != nil {
+				return err
+			}
+			return insertStockMovement(ctx, &m, product)
+		})
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		updateStockAlert(ctx, productID)
+		return &m, nil
+	}
+	return nil, errStockConflict
+}
+
+// productUnitCost is the cost used to value stock: the configured cost price or, if there
+// is none, the cost of the last purchase.
+func productUnitCost(product models.Product) float64 {
+	if product.CostPrice > 0 {
+		return product.CostPrice
+	}
+	return product.LastUnitCost
+}
+
+// insertStockMovement completes the movement with the product state after the change and
+// stores it. It must run in the same transaction as the stock change.
+func insertStockMovement(ctx context.Context, m *models.StockMovement, product models.Product) error {
+	m.ID = primitive.NewObjectID()
+	m.StockAfter = product.Stock
+	m.CreatedAt = time.Now()
+	if m.Unit == "" {
+		m.Unit = product.Measurement
+	}
+	if m.Type == models.MovementWaste && m.UnitCost == 0 {
+		m.UnitCost = productUnitCost(product)
+	}
+	_, err := database.StockMovementsCollection.InsertOne(ctx, m)
+	return err
+}
+
+// updateStockAlert re-evaluates the low-stock alert after a committed stock change. Inside
+// a caller's transaction it does nothing: the caller updates the alerts after its commit.
+// The alert is a notice, so failures are only logged.
+func updateStockAlert(ctx context.Context, productID primitive.ObjectID) {
+	if mongo.SessionFromContext(ctx) != nil {
+		return
+	}
+	if err := evaluateStockAlert(ctx, productID); err != nil {
+		log.Printf("❌ Error evaluando alerta de stock de %s: %v", productID.Hex(), err)
+	}
+}
+
+// movementTotals are the sum of the movements of a product and whether it has its opening one.
+type movementTotals struct {
+	Sum        float64   `bson:"sum"`
+	First      time.Time `bson:"first"`
+	HasOpening bool      `bson:"hasOpening"`
+}
+
+// productMovementTotals sums the movements per product (of one product if productID is set).
+func productMovementTotals(ctx context.Context, productID *primitive.ObjectID) (map[primitive.ObjectID]movementTotals, error) {
+	pipeline := mongo.Pipeline{}
+	if productID != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"productId": *productID}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: bson.M{
+		"_id":   "$productId",
+		"sum":   bson.M{"$sum": "$quantity"},
+		"first": bson.M{"$min": "$createdAt"},
+		"hasOpening": bson.M{"$max": bson.M{
+			"$eq": bson.A{"$referenceType", initialStockReferenceType},
+		}},
+	}}})
+
+	cursor, err := database.StockMovementsCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Totals    movementTotals     `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[primitive.ObjectID]movementTotals, len(rows))
+	for _, row := range rows {
+		totals[row.ProductID] = row.Totals
+	}
+	return totals, nil
+}
+
+// MigrateOpeningBalances gives every product whose stock predates the movement history an
+// opening adjustment, so that its movements add up to its stock. It is idempotent and runs
+// on every startup.
+func MigrateOpeningBalances() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	totals, err := productMovementTotals(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := database.StockCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, candidate := range products {
+		if t := totals[candidate.ID]; t.HasOpening || math.Abs(candidate.Stock-t.Sum) < stockEpsilon {
+			continue
+		}
+
+		// Stock y movimientos se releen juntos: un movimiento en el medio no cambia la diferencia
+		inserted := false
+		err := database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+			inserted = false
+			var product models.Product
+			if err := database.StockCollection.FindOne(ctx, bson.M{"_id": candidate.ID}).Decode(&product); err != nil {
+				return err
+			}
+			current, err := productMovementTotals(ctx, &product.ID)
+			if err != nil {
+				return err
+			}
+			t := current[product.ID]
+			opening := product.Stock - t.Sum
+			if t.HasOpening || math.Abs(opening) < stockEpsilon {
+				return nil
+			}
+
+			m := models.StockMovement{
+				ID:            primitive.NewObjectID(),
+				StoreID:       product.StoreID,
+				ProductID:     product.ID,
+				Type:          models.MovementAdjustment,
+				Quantity:      opening,
+				Unit:          product.Measurement,
+				StockAfter:    opening,
+				Reason:        "Saldo inicial",
+				ReferenceType: initialStockReferenceType,
+				CreatedAt:     time.Now(),
+			}
+			// Va antes que el resto del historial
+			if !t.First.IsZero() {
+				m.CreatedAt = t.First.Add(-time.Second)
+			}
+			if _, err := database.StockMovementsCollection.InsertOne(ctx, m); err != nil {
+				return err
+			}
+			inserted = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if inserted {
+			migrated++
+		}
+	}
+
+	if migrated > 0 {
+		log.Printf("✅ %d productos con saldo inicial en el historial de stock", migrated)
+	}
+	return nil
+}
+
+// CreateStockMovementHandler registers a stock movement for a product.
+// For purchase and sale the quantity is the positive amount that came in or went out; for
+// adjustment and transfer it is the signed difference. Waste goes through CreateWasteHandler.
+func CreateStockMovementHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Type     models.MovementType `json:"type" binding:"required"`
+		Quantity float64             `json:"quantity"`
+		Unit     models.Measurement  `json:"unit"` // Unidad de la cantidad (por defecto la base del producto)
+		Reason   string              `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !input.Type.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de movimiento inválido"})
+		return
+	}
+	if input.Quantity == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La cantidad no puede ser cero"})
+		return
+	}
+	// La merma necesita su motivo para el reporte: se registra por su propio endpoint
+	if input.Type == models.MovementWaste {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Las mermas se registran con su motivo en /stock/:id/waste"})
+		return
+	}
+
+	quantity := input.Quantity
+	switch input.Type {
+	case models.MovementPurchase, models.MovementSale:
+		if quantity < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "La cantidad debe ser positiva"})
+			return
+		}
+		if input.Type != models.MovementPurchase {
+			quantity = -quantity
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	base, product, err := quantityInBase(ctx, storeID, productID, quantity, input.Unit)
+	if errors.Is(err, errUnknownUnit) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El producto no tiene configurada la unidad " + string(input.Unit)})
+		return
+	}
+	movement := models.StockMovement{
+		StoreID:   storeID,
+		ProductID: productID,
+		Type:      input.Type,
+		Quantity:  base,
+		UserID:    actingUserFromContext(c),
+		Reason:    strings.TrimSpace(input.Reason),
+	}
+	if err == nil {
+		enteredAs(&movement, product, quantity, input.Unit)
+		movement, err = applyStockMovement(ctx, movement)
+	}
+	if errors.Is(err, errProductNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	}
+	if errors.Is(err, errProductArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto está archivado: restauralo para modificarlo"})
+		return
+	}
+	if errors.Is(err, errInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "La salida supera el stock: registrá primero un conteo"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar movimiento"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, movement)
+}
+
+// GetStockMovementsHandler returns the movement history of a product, newest first.
+// Filters: type, from, to, page, limit.
+func GetStockMovementsHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	page, limit := paginationParams(c)
+
+	filter := bson.M{"storeId": storeID, "productId": productID}
+	if movementType := models.MovementType(c.Query("type")); movementType != "" {
+		if !movementType.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Tipo de movimiento inválido"})
+			return
+		}
+		filter["type"] = movementType
+	}
+	createdAt, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if createdAt != nil {
+		filter["createdAt"] = createdAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	total, err := database.StockMovementsCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al contar movimientos"})
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := database.StockMovementsCollection.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener movimientos"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	movements := []models.StockMovement{}
+	if err := cursor.All(ctx, &movements); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar movimientos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items": movements,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
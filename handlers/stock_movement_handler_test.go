@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"testing"
+
+	"verdustock-auth/models"
+)
+
+func TestGuardedOutflow(t *testing.T) {
+	tests := []struct {
+		t    models.MovementType
+		want bool
+	}{
+		{models.MovementWaste, true},
+		{models.MovementSale, true},
+		{models.MovementTransfer, true},
+		{models.MovementAdjustment, false}, // un conteo corrige el stock, puede bajarlo a lo contado
+		{models.MovementPurchase, false},   // anular un remito devuelve lo que sumó
+	}
+	for _, tt := range tests {
+		if got := guardedOutflow(tt.t); got != tt.want {
+			t.Errorf("guardedOutflow(%s) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
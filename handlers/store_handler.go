@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// currentStoreID returns the store of the logged in user (set by AuthMiddleware).
+// If it is missing it writes the error response and returns false.
+func currentStoreID(c *gin.Context) (primitive.ObjectID, bool) {
+	storeID, err := primitive.ObjectIDFromHex(c.GetString("storeId"))
+	if err != nil || storeID.IsZero() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Tienda no identificada"})
+		return primitive.NilObjectID, false
+	}
+	return storeID, true
+}
+
+// createStoreForUser creates a one-member store owned by the user.
+func createStoreForUser(ctx context.Context, user models.User, name string) (models.Store, error) {
+	if strings.TrimSpace(name) == "" {
+		name = fmt.Sprintf("Verdulería de %s", user.Username)
+	}
+
+	store := models.Store{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		OwnerID:   user.ID,
+		CreatedAt: time.Now(),
+	}
+	_, err := database.StoresCollection.InsertOne(ctx, store)
+	return store, err
+}
+
+// GetStoreHandler returns the store of the logged in user
+func GetStoreHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var store models.Store
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": storeID}).Decode(&store); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tienda no encontrada"})
+		return
+	}
+
+	c.JSON(http.StatusOK, store)
+}
+
+// UpdateStoreHandler lets the owner rename the store
+func UpdateStoreHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El nombre es requerido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := database.StoresCollection.UpdateOne(ctx,
+		bson.M{"_id": storeID},
+		bson.M{"$set": bson.M{"name": strings.TrimSpace(input.Name)}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar tienda"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tienda actualizada correctamente"})
+}
+
+// ListStoreMembersHandler returns the users that belong to the store
+func ListStoreMembersHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+	cursor, err := database.UserCollection.Find(ctx, bson.M{"storeId": storeID}, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener miembros"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar miembros"})
+		return
+	}
+
+	members := []gin.H{}
+	for _, u := range users {
+		members = append(members, gin.H{
+			"id":       u.ID.Hex(),
+			"email":    u.Email,
+			"username": u.Username,
+			"role":     u.GetRole(),
+			"hasPin":   u.PINHash != "",
+		})
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// UpdateMemberRoleHandler lets the owner change the role of another member
+func UpdateMemberRoleHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	memberID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de usuario inválido"})
+		return
+	}
+
+	var input struct {
+		Role models.Role `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !input.Role.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rol inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var store models.Store
+	if err := database.StoresCollection.FindOne(ctx, bson.M{"_id": storeID}).Decode(&store); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tienda no encontrada"})
+		return
+	}
+	if store.OwnerID == memberID && input.Role != models.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No se puede cambiar el rol del dueño de la tienda"})
+		return
+	}
+
+	result, err := database.UserCollection.UpdateOne(ctx,
+		bson.M{"_id": memberID, "storeId": storeID},
+		bson.M{"$set": bson.M{"role": input.Role}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar rol"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado en la tienda"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rol actualizado correctamente"})
+}
+
+// MigrateUsersToStores converts every user without a store into the owner of a
+// one-member store, moving its Mercado Pago link and re-keying its products,
+// sells and payments by store. It is idempotent and runs on every startup.
+func MigrateUsersToStores() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cursor, err := database.UserCollection.Find(ctx, bson.M{"storeId": bson.M{"$exists": false}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		// Leemos también los campos viejos de Mercado Pago que ya no están en models.User
+		var legacy struct {
+			models.User        `bson:",inline"`
+			MPAccount          *models.MPAccount `bson:"mpAccount,omitempty"`
+			MPAccountConnected bool              `bson:"mpAccountConnected"`
+		}
+		if err := cursor.Decode(&legacy); err != nil {
+			return err
+		}
+		user := legacy.User
+
+		// Si una corrida anterior quedó a medias, reutilizamos la tienda ya creada
+		var store models.Store
+		err := database.StoresCollection.FindOne(ctx, bson.M{"ownerId": user.ID}).Decode(&store)
+		if err == mongo.ErrNoDocuments {
+			store, err = createStoreForUser(ctx, user, "")
+		}
+		if err != nil {
+			return err
+		}
+
+		if legacy.MPAccount != nil {
+			_, err = database.StoresCollection.UpdateOne(ctx,
+				bson.M{"_id": store.ID},
+				bson.M{"$set": bson.M{"mpAccount": legacy.MPAccount, "mpAccountConnected": legacy.MPAccountConnected}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		owned := bson.M{"userId": user.ID, "storeId": bson.M{"$exists": false}}
+		if _, err := database.StockCollection.UpdateMany(ctx, owned, bson.M{"$set": bson.M{"storeId": store.ID}, "$unset": bson.M{"userId": ""}}); err != nil {
+			return err
+		}
+		if _, err := database.MPPaymentsCollection.UpdateMany(ctx, owned, bson.M{"$set": bson.M{"storeId": store.ID}, "$unset": bson.M{"userId": ""}}); err != nil {
+			return err
+		}
+		// En las ventas conservamos userId: indica quién la registró
+		if _, err := database.SellsCollection.UpdateMany(ctx, owned, bson.M{"$set": bson.M{"storeId": store.ID}}); err != nil {
+			return err
+		}
+
+		_, err = database.UserCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID},
+			bson.M{
+				"$set":   bson.M{"storeId": store.ID, "role": user.GetRole()},
+				"$unset": bson.M{"mpAccount": "", "mpAccountConnected": ""},
+			},
+		)
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Printf("✅ Migración: %d usuarios convertidos en tiendas", migrated)
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,485 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type supplierInput struct {
+	Name  string `json:"name" binding:"required"`
+	CUIT  string `json:"cuit"`
+	Phone string `json:"phone"`
+	Notes string `json:"notes"`
+}
+
+// normalizeCUIT strips dashes and spaces and checks the length and verification digit.
+// An empty CUIT is allowed (many puesteros don't give one).
+func normalizeCUIT(cuit string) (string, bool) {
+	digits := strings.NewReplacer("-", "", " ", "", ".", "").Replace(cuit)
+	if digits == "" {
+		return "", true
+	}
+	if len(digits) != 11 {
+		return "", false
+	}
+
+	weights := []int{5, 4, 3, 2, 7, 6, 5, 4, 3, 2}
+	sum := 0
+	for i, r := range digits {
+		if r < '0' || r > '9' {
+			return "", false
+		}
+		if i < len(weights) {
+			sum += int(r-'0') * weights[i]
+		}
+	}
+	check := 11 - sum%11
+	if check == 11 {
+		check = 0
+	}
+	if check == 10 || check != int(digits[10]-'0') {
+		return "", false
+	}
+	return digits, true
+}
+
+// bindSupplierInput reads and validates the supplier body; on failure it writes the response.
+func bindSupplierInput(c *gin.Context) (supplierInput, bool) {
+	var input supplierInput
+	if err := c.ShouldBindJSON(&input); err != nil || strings.TrimSpace(input.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El nombre del proveedor es requerido"})
+		return input, false
+	}
+	cuit, ok := normalizeCUIT(input.CUIT)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CUIT inválido"})
+		return input, false
+	}
+	input.Name = strings.TrimSpace(input.Name)
+	input.CUIT = cuit
+	input.Phone = strings.TrimSpace(input.Phone)
+	input.Notes = strings.TrimSpace(input.Notes)
+	return input, true
+}
+
+// findStoreSupplier loads a supplier of the store by id.
+func findStoreSupplier(ctx context.Context, storeID, supplierID primitive.ObjectID) (models.Supplier, error) {
+	var supplier models.Supplier
+	err := database.SuppliersCollection.FindOne(ctx, bson.M{"_id": supplierID, "storeId": storeID}).Decode(&supplier)
+	return supplier, err
+}
+
+// CreateSupplierHandler adds a supplier to the store
+func CreateSupplierHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	input, ok := bindSupplierInput(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	supplier := models.Supplier{
+		ID:        primitive.NewObjectID(),
+		StoreID:   storeID,
+		Name:      input.Name,
+		CUIT:      input.CUIT,
+		Phone:     input.Phone,
+		Notes:     input.Notes,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := database.SuppliersCollection.InsertOne(ctx, supplier); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al crear proveedor"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, supplier)
+}
+
+// GetSuppliersHandler lists the suppliers of the store by name
+func GetSuppliersHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.SuppliersCollection.Find(ctx,
+		bson.M{"storeId": storeID},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener proveedores"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	suppliers := []models.Supplier{}
+	if err := cursor.All(ctx, &suppliers); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar proveedores"})
+		return
+	}
+
+	c.JSON(http.StatusOK, suppliers)
+}
+
+// GetSupplierHandler returns a supplier with the products linked to it
+func GetSupplierHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	supplierID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	supplier, err := findStoreSupplier(ctx, storeID, supplierID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proveedor no encontrado"})
+		return
+	}
+
+	cursor, err := database.StockCollection.Find(ctx,
+		bson.M{"storeId": storeID, "supplierIds": supplierID},
+		options.Find().SetProjection(bson.M{"name": 1, "measurement": 1, "lastUnitCost": 1}),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener productos del proveedor"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	products := []models.Product{}
+	if err := cursor.All(ctx, &products); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar productos"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"supplier": supplier, "products": products})
+}
+
+// UpdateSupplierHandler replaces the data of a supplier
+func UpdateSupplierHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	supplierID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+		return
+	}
+	input, ok := bindSupplierInput(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var supplier models.Supplier
+	err = database.SuppliersCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": supplierID, "storeId": storeID},
+		bson.M{"$set": bson.M{
+			"name":      input.Name,
+			"cuit":      input.CUIT,
+			"phone":     input.Phone,
+			"notes":     input.Notes,
+			"updatedAt": time.Now(),
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&supplier)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proveedor no encontrado"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar proveedor"})
+		return
+	}
+
+	c.JSON(http.StatusOK, supplier)
+}
+
+// DeleteSupplierHandler deletes a supplier that never had a receipt; otherwise its history would be orphaned.
+func DeleteSupplierHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	supplierID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	used, err := database.PurchaseReceiptsCollection.CountDocuments(ctx, bson.M{"storeId": storeID, "supplierId": supplierID}, options.Count().SetLimit(1))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al verificar remitos del proveedor"})
+		return
+	}
+	if used > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El proveedor tiene remitos cargados y no se puede eliminar"})
+		return
+	}
+
+	result, err := database.SuppliersCollection.DeleteOne(ctx, bson.M{"_id": supplierID, "storeId": storeID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al eliminar proveedor"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proveedor no encontrado"})
+		return
+	}
+
+	_, _ = database.StockCollection.UpdateMany(ctx,
+		bson.M{"storeId": storeID, "supplierIds": supplierID},
+		bson.M{"$pull": bson.M{"supplierIds": supplierID}},
+	)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Proveedor eliminado"})
+}
+
+// LinkSupplierProductHandler marks that the supplier sells a product
+func LinkSupplierProductHandler(c *gin.Context) {
+	setSupplierProduct(c, "$addToSet")
+}
+
+// UnlinkSupplierProductHandler removes a product from the supplier
+func UnlinkSupplierProductHandler(c *gin.Context) {
+	setSupplierProduct(c, "$pull")
+}
+
+func setSupplierProduct(c *gin.Context, operator string) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	supplierID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+		return
+	}
+	productID, err := primitive.ObjectIDFromHex(c.Param("productId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := findStoreSupplier(ctx, storeID, supplierID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proveedor no encontrado"})
+		return
+	}
+
+	result, err := database.StockCollection.UpdateOne(ctx,
+		bson.M{"_id": productID, "storeId": storeID},
+		bson.M{operator: bson.M{"supplierIds": supplierID}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al actualizar producto"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Producto actualizado correctamente"})
+}
+
+// recordSupplierPrices stores the price of every line of a confirmed receipt and links
+// the products to the supplier. Receipts without a supplier from the directory are skipped.
+func recordSupplierPrices(ctx context.Context, receipt models.PurchaseReceipt) error {
+	if receipt.SupplierID == nil {
+		return nil
+	}
+
+	documents := make([]interface{}, 0, len(receipt.Lines))
+	productIDs := make([]primitive.ObjectID, 0, len(receipt.Lines))
+	for _, line := range receipt.Lines {
+		// Los precios se guardan por unidad base, así se pueden comparar compras en distintas unidades
+		unit := line.BaseUnit
+		if unit == "" {
+			unit = line.Measurement
+		}
+		documents = append(documents, models.SupplierPrice{
+			ID:          primitive.NewObjectID(),
+			StoreID:     receipt.StoreID,
+			SupplierID:  *receipt.SupplierID,
+			ProductID:   line.ProductID,
+			ReceiptID:   receipt.ID,
+			UnitCost:    roundMoney(line.BaseUnitCost()),
+			Quantity:    line.StockQuantity(),
+			Measurement: unit,
+			Date:        receipt.Date,
+		})
+		productIDs = append(productIDs, line.ProductID)
+	}
+	if len(documents) == 0 {
+		return nil
+	}
+
+	if _, err := database.SupplierPricesCollection.InsertMany(ctx, documents); err != nil {
+		return err
+	}
+	_, err := database.StockCollection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": productIDs}, "storeId": receipt.StoreID},
+		bson.M{"$addToSet": bson.M{"supplierIds": *receipt.SupplierID}},
+	)
+	return err
+}
+
+// supplierPriceSummary is the last and average price a supplier charged for a product.
+type supplierPriceSummary struct {
+	SupplierID   primitive.ObjectID `bson:"_id" json:"supplierId"`
+	SupplierName string             `bson:"-" json:"supplierName"`
+	ProductID    primitive.ObjectID `bson:"productId" json:"productId"`
+	LastPrice    float64            `bson:"lastPrice" json:"lastPrice"`
+	LastDate     time.Time          `bson:"lastDate" json:"lastDate"`
+	// Promedio ponderado por cantidad comprada
+	AveragePrice float64 `bson:"averagePrice" json:"averagePrice"`
+	MinPrice     float64 `bson:"minPrice" json:"minPrice"`
+	MaxPrice     float64 `bson:"maxPrice" json:"maxPrice"`
+	Purchases    int64   `bson:"purchases" json:"purchases"`
+	Quantity     float64 `bson:"quantity" json:"quantity"`
+}
+
+// GetProductSupplierPricesHandler compares what each supplier charged for a product:
+// last and average price over the period (from/to), plus the full history if history=true.
+func GetProductSupplierPricesHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	match := bson.M{"storeId": storeID, "productId": productID}
+	if supplierHex := c.Query("supplierId"); supplierHex != "" {
+		supplierID, err := primitive.ObjectIDFromHex(supplierHex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de proveedor inválido"})
+			return
+		}
+		match["supplierId"] = supplierID
+	}
+	date, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if date != nil {
+		match["date"] = date
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "date", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$supplierId",
+			"productId": bson.M{"$first": "$productId"},
+			"lastPrice": bson.M{"$first": "$unitCost"},
+			"lastDate":  bson.M{"$first": "$date"},
+			"spent":     bson.M{"$sum": bson.M{"$multiply": bson.A{"$unitCost", "$quantity"}}},
+			"quantity":  bson.M{"$sum": "$quantity"},
+			"minPrice":  bson.M{"$min": "$unitCost"},
+			"maxPrice":  bson.M{"$max": "$unitCost"},
+			"purchases": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"averagePrice": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$quantity", 0}},
+				bson.M{"$divide": bson.A{"$spent", "$quantity"}},
+				0,
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "lastPrice", Value: 1}}}},
+	}
+
+	cursor, err := database.SupplierPricesCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener precios"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	summaries := []supplierPriceSummary{}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar precios"})
+		return
+	}
+
+	// Nombres de los proveedores
+	names := map[primitive.ObjectID]string{}
+	supplierCursor, err := database.SuppliersCollection.Find(ctx, bson.M{"storeId": storeID})
+	if err == nil {
+		var suppliers []models.Supplier
+		if supplierCursor.All(ctx, &suppliers) == nil {
+			for _, s := range suppliers {
+				names[s.ID] = s.Name
+			}
+		}
+	}
+	for i := range summaries {
+		summaries[i].AveragePrice = roundMoney(summaries[i].AveragePrice)
+		summaries[i].SupplierName = names[summaries[i].SupplierID]
+	}
+
+	response := gin.H{"suppliers": summaries}
+	if c.Query("history") == "true" {
+		historyCursor, err := database.SupplierPricesCollection.Find(ctx, match,
+			options.Find().SetSort(bson.D{{Key: "date", Value: -1}}).SetLimit(500),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al obtener historial de precios"})
+			return
+		}
+		defer historyCursor.Close(ctx)
+
+		history := []models.SupplierPrice{}
+		if err := historyCursor.All(ctx, &history); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar historial de precios"})
+			return
+		}
+		response["history"] = history
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,27 @@
+package handlers
+
+import "testing"
+
+func TestNormalizeCUIT(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{"20-12345678-6", "20123456786", true},
+		{"33 69345023 9", "33693450239", true}, // AFIP
+		{"23.00000000.0", "23000000000", true}, // 11 - 0 = 11 => dígito 0
+		{"20-12345678-5", "", false},           // dígito verificador incorrecto
+		{"20-00000001-0", "", false},           // el dígito daría 10: ningún CUIT así es válido
+		{"20-1234567-6", "", false},            // corto
+		{"20-123456789-6", "", false},          // largo
+		{"2O-12345678-6", "", false},           // letra O en lugar de cero
+	}
+	for _, tt := range tests {
+		got, ok := normalizeCUIT(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("normalizeCUIT(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -44,14 +44,18 @@ func SyncMPTransfersHandler(c *gin.Context) {
 		return
 	}
 	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	var user models.User
-	err := database.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
-	if err != nil || user.MPAccount == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Usuario no tiene Mercado Pago vinculado"})
+	var store models.Store
+	err := database.StoresCollection.FindOne(ctx, bson.M{"_id": storeID}).Decode(&store)
+	if err != nil || store.MPAccount == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La tienda no tiene Mercado Pago vinculado"})
 		return
 	}
 
@@ -82,7 +86,7 @@ func SyncMPTransfersHandler(c *gin.Context) {
 
 	client := &http.Client{Timeout: 10 * time.Second}
 	req, _ := http.NewRequest("GET", finalURL, nil)
-	req.Header.Set("Authorization", "Bearer "+user.MPAccount.AccessToken)
+	req.Header.Set("Authorization", "Bearer "+store.MPAccount.AccessToken)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -114,10 +118,10 @@ func SyncMPTransfersHandler(c *gin.Context) {
 			continue
 		}
 
-		// Verificar duplicados (ID Pago + ID Usuario)
+		// Verificar duplicados (ID Pago + ID Tienda)
 		count, _ := database.MPPaymentsCollection.CountDocuments(ctx, bson.M{
 			"mpPaymentId": payment.ID,
-			"userId":      user.ID,
+			"storeId":     store.ID,
 		})
 
 		if count > 0 {
@@ -143,7 +147,7 @@ func SyncMPTransfersHandler(c *gin.Context) {
 
 		mpPayment := models.MPPayment{
 			ID:          primitive.NewObjectID(),
-			UserID:      user.ID,
+			StoreID:     store.ID,
 			MPPaymentID: payment.ID,
 			Amount:      payment.TransactionAmount,
 			PayerEmail:  payment.Payer.Email,
@@ -159,7 +163,8 @@ func SyncMPTransfersHandler(c *gin.Context) {
 		// Crear Venta
 		sell := models.Sell{
 			ID:       primitive.NewObjectID(),
-			UserID:   user.ID,
+			StoreID:  store.ID,
+			UserID:   userID,
 			Amount:   payment.TransactionAmount,
 			Date:     realDate, // Guardamos fecha real
 			Type:     "Transferencia",
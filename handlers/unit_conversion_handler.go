@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errUnknownUnit = errors.New("el producto no tiene configurada esa unidad")
+
+// quantityInBase converts a quantity entered in unit to the base unit of the product.
+// An empty unit means the base unit.
+func quantityInBase(ctx context.Context, storeID, productID primitive.ObjectID, quantity float64, unit models.Measurement) (float64, models.Product, error) {
+	var product models.Product
+	err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, productID)).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return 0, product, missingProductError(ctx, storeID, productID)
+	}
+	if err != nil {
+		return 0, product, err
+	}
+
+	base, ok := product.ToBase(quantity, unit)
+	if !ok {
+		return 0, product, errUnknownUnit
+	}
+	return base, product, nil
+}
+
+// enteredAs fills the movement with the quantity as it was entered, when it was not in the base unit.
+func enteredAs(m *models.StockMovement, product models.Product, quantity float64, unit models.Measurement) {
+	if unit != "" && unit != product.Measurement {
+		m.EnteredQuantity = quantity
+		m.EnteredUnit = unit
+	}
+}
+
+// validateConversions checks units and factors of a product's conversion table.
+func validateConversions(base models.Measurement, conversions []models.UnitConversion) error {
+	seen := map[models.Measurement]bool{}
+	for _, conversion := range conversions {
+		switch {
+		case !conversion.Unit.Valid():
+			return validationError{fmt.Sprintf("Unidad inválida: %s", conversion.Unit)}
+		case conversion.Unit == base:
+			return validationError{"No hace falta convertir la unidad base"}
+		case seen[conversion.Unit]:
+			return validationError{fmt.Sprintf("La unidad %s está repetida", conversion.Unit)}
+		case conversion.Factor <= 0:
+			return validationError{fmt.Sprintf("El factor de %s debe ser mayor a cero", conversion.Unit)}
+		}
+		seen[conversion.Unit] = true
+	}
+	return nil
+}
+
+// SetConversionsHandler replaces the unit conversions of a product
+// (e.g. tomate in KILOS: one CAJON = 18).
+func SetConversionsHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Conversions []models.UnitConversion `json:"conversions"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Datos inválidos"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var product models.Product
+	err = database.StockCollection.FindOne(ctx, activeProductFilter(storeID, productID)).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		err = missingProductError(ctx, storeID, productID)
+	}
+	if errors.Is(err, errProductArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto está archivado: restauralo para modificarlo"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	}
+	if err := validateConversions(product.Measurement, input.Conversions); err != nil {
+		respondValidationError(c, err, "Error al validar conversiones")
+		return
+	}
+
+	// Si justo cambió la unidad base, los factores ya no tendrían sentido
+	result, err := database.StockCollection.UpdateOne(ctx,
+		bson.M{"_id": productID, "storeId": storeID, "archivedAt": nil, "measurement": product.Measurement},
+		bson.M{"$set": bson.M{"conversions": input.Conversions}},
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al guardar conversiones"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto cambió de unidad, volvé a intentar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"measurement": product.Measurement, "conversions": input.Conversions})
+}
+
+// hasValues reports whether the product has stock, minimum or prices expressed in its base unit.
+func hasValues(product models.Product) bool {
+	return product.Stock != 0 || product.MinStock != 0 || product.CostPrice != 0 || product.SalePrice != 0 ||
+		product.LastUnitCost != 0 || len(product.ListPrices) > 0 || len(product.Conversions) > 0
+}
+
+// rebaseProduct returns the product expressed in another base unit, with stock, prices and
+// the conversion table converted so that they keep meaning the same amounts, and the factor
+// (how many old units make one new unit). The target unit must be among the conversions,
+// unless the product has nothing to convert yet.
+func rebaseProduct(before models.Product, unit models.Measurement) (models.Product, float64, error) {
+	if !unit.Valid() {
+		return before, 0, validationError{fmt.Sprintf("Unidad inválida: %s", unit)}
+	}
+	if before.Measurement == unit {
+		return before, 1, nil
+	}
+
+	factor, ok := before.UnitFactor(unit)
+	if !ok {
+		if hasValues(before) {
+			return before, 0, validationError{fmt.Sprintf("Configurá cuánto es un/a %s de %s antes de cambiar la unidad", unit, before.Name)}
+		}
+		factor = 1 // Sin stock ni precios: solo cambia la etiqueta
+	}
+
+	// Una unidad nueva son "factor" unidades viejas
+	after := before
+	after.Measurement = unit
+	after.Stock = before.Stock / factor
+	after.MinStock = before.MinStock / factor
+	after.CostPrice = roundMoney(before.CostPrice * factor)
+	after.SalePrice = roundMoney(before.SalePrice * factor)
+	after.LastUnitCost = roundMoney(before.LastUnitCost * factor)
+	after.ListPrices = make(map[string]float64, len(before.ListPrices))
+	for code, price := range before.ListPrices {
+		after.ListPrices[code] = roundMoney(price * factor)
+	}
+	after.Conversions = []models.UnitConversion{}
+	for _, conversion := range before.Conversions {
+		if conversion.Unit != unit {
+			after.Conversions = append(after.Conversions, models.UnitConversion{Unit: conversion.Unit, Factor: conversion.Factor / factor})
+		}
+	}
+	if before.Measurement.Valid() {
+		after.Conversions = append(after.Conversions, models.UnitConversion{Unit: before.Measurement, Factor: 1 / factor})
+	}
+	return after, factor, nil
+}
+
+// rebaseHistory converts the movements and supplier prices of the product to the new base
+// unit, so that reports and sums never mix units. What was typed stays in enteredQuantity
+// and enteredUnit. It must run in the same transaction as the product change.
+func rebaseHistory(ctx context.Context, before, after models.Product, factor float64) error {
+	_, err := database.StockMovementsCollection.UpdateMany(ctx,
+		bson.M{"productId": before.ID},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"enteredQuantity": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$enteredUnit", false}}, "$enteredQuantity", "$quantity"}},
+			"enteredUnit":     bson.M{"$ifNull": bson.A{"$enteredUnit", "$unit"}},
+			"quantity":        bson.M{"$divide": bson.A{"$quantity", factor}},
+			"stockAfter":      bson.M{"$divide": bson.A{"$stockAfter", factor}},
+			"unitCost":        bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$unitCost", 0}}, bson.M{"$multiply": bson.A{"$unitCost", factor}}, "$$REMOVE"}},
+			"unit":            after.Measurement,
+		}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.SupplierPricesCollection.UpdateMany(ctx,
+		bson.M{"productId": before.ID, "measurement": before.Measurement},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"unitCost":    bson.M{"$multiply": bson.A{"$unitCost", factor}},
+			"quantity":    bson.M{"$divide": bson.A{"$quantity", factor}},
+			"measurement": after.Measurement,
+		}}}},
+	)
+	return err
+}
+
+// changeBaseUnit switches the base unit of a product (see rebaseProduct), converting its
+// history too, all in one transaction.
+func changeBaseUnit(ctx context.Context, storeID, productID primitive.ObjectID, unit models.Measurement, userID primitive.ObjectID) error {
+	if !unit.Valid() {
+		return validationError{fmt.Sprintf("Unidad inválida: %s", unit)}
+	}
+
+	for i := 0; i < countRetries; i++ {
+		var before models.Product
+		err := database.StockCollection.FindOne(ctx, activeProductFilter(storeID, productID)).Decode(&before)
+		if err == mongo.ErrNoDocuments {
+			return missingProductError(ctx, storeID, productID)
+		}
+		if err != nil {
+			return err
+		}
+		if before.Measurement == unit {
+			return nil
+		}
+
+		after, factor, err := rebaseProduct(before, unit)
+		if err != nil {
+			return err
+		}
+
+		err = database.WithTransaction(ctx, func(ctx mongo.SessionContext) error {
+			// Solo si nadie movió el stock ni la unidad desde que lo leímos
+			result, err := database.StockCollection.UpdateOne(ctx,
+				bson.M{"_id": productID, "storeId": storeID, "archivedAt": nil, "stock": before.Stock, "measurement": before.Measurement},
+				bson.M{"$set": bson.M{
+					"measurement":  after.Measurement,
+					"stock":        after.Stock,
+					"minStock":     after.MinStock,
+					"costPrice":    after.CostPrice,
+					"salePrice":    after.SalePrice,
+					"lastUnitCost": after.LastUnitCost,
+					"listPrices":   after.ListPrices,
+					"conversions":  after.Conversions,
+				}},
+			)
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount == 0 {
+				return errStockConflict
+			}
+			if err := rebaseHistory(ctx, before, after, factor); err != nil {
+				return err
+			}
+
+			// El cambio queda a la vista en el historial (no mueve stock)
+			if before.Stock == 0 {
+				return nil
+			}
+			movement := models.StockMovement{
+				StoreID:   storeID,
+				ProductID: productID,
+				Type:      models.MovementAdjustment,
+				Quantity:  0,
+				UserID:    userID,
+				Reason:    fmt.Sprintf("Cambio de unidad: %v %s = %v %s", before.Stock, before.Measurement, after.Stock, after.Measurement),
+			}
+			return insertStockMovement(ctx, &movement, after)
+		})
+		if err == errStockConflict {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		updateStockAlert(ctx, productID)
+		recordPriceChanges(ctx, priceChanges(before, after), userID, models.PriceSourceManual, nil)
+		return nil
+	}
+	return errStockConflict
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+
+	"verdustock-auth/models"
+)
+
+func TestRebaseProductRoundTrip(t *testing.T) {
+	original := models.Product{
+		Name:         "Tomate",
+		Measurement:  models.Kilos,
+		Stock:        45,
+		MinStock:     9,
+		CostPrice:    1200,
+		SalePrice:    1800,
+		LastUnitCost: 1150,
+		ListPrices:   map[string]float64{"mayorista": 1500},
+		Conversions:  []models.UnitConversion{{Unit: models.Cajones, Factor: 18}, {Unit: models.Bolsas, Factor: 3}},
+	}
+
+	cajones, factor, err := rebaseProduct(original, models.Cajones)
+	if err != nil {
+		t.Fatalf("KILOS -> CAJONES: %v", err)
+	}
+	if factor != 18 || cajones.Stock != 2.5 || cajones.MinStock != 0.5 || cajones.SalePrice != 32400 {
+		t.Errorf("KILOS -> CAJONES = factor %v, stock %v, min %v, sale %v", factor, cajones.Stock, cajones.MinStock, cajones.SalePrice)
+	}
+	if bolsa, ok := cajones.UnitFactor(models.Bolsas); !ok || math.Abs(bolsa-3.0/18) > 1e-12 {
+		t.Errorf("BOLSAS in CAJONES = %v, %v; want %v", bolsa, ok, 3.0/18)
+	}
+	if kilo, ok := cajones.UnitFactor(models.Kilos); !ok || math.Abs(kilo-1.0/18) > 1e-12 {
+		t.Errorf("KILOS in CAJONES = %v, %v; want %v", kilo, ok, 1.0/18)
+	}
+
+	back, _, err := rebaseProduct(cajones, models.Kilos)
+	if err != nil {
+		t.Fatalf("CAJONES -> KILOS: %v", err)
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	if back.Measurement != models.Kilos || !near(back.Stock, original.Stock) || !near(back.MinStock, original.MinStock) {
+		t.Errorf("round trip stock = %v %s (min %v), want %v %s (min %v)", back.Stock, back.Measurement, back.MinStock, original.Stock, original.Measurement, original.MinStock)
+	}
+	if back.CostPrice != original.CostPrice || back.SalePrice != original.SalePrice || back.LastUnitCost != original.LastUnitCost || back.ListPrices["mayorista"] != 1500 {
+		t.Errorf("round trip prices = %v/%v/%v/%v", back.CostPrice, back.SalePrice, back.LastUnitCost, back.ListPrices)
+	}
+	if factor, ok := back.UnitFactor(models.Cajones); !ok || !near(factor, 18) {
+		t.Errorf("round trip CAJONES factor = %v, %v", factor, ok)
+	}
+	if factor, ok := back.UnitFactor(models.Bolsas); !ok || !near(factor, 3) {
+		t.Errorf("round trip BOLSAS factor = %v, %v", factor, ok)
+	}
+}
+
+func TestRebaseProductWithoutConversion(t *testing.T) {
+	withStock := models.Product{Name: "Lechuga", Measurement: models.Unidades, Stock: 10}
+	if _, _, err := rebaseProduct(withStock, models.Kilos); err == nil {
+		t.Error("changing a product with stock to an unconfigured unit should fail")
+	}
+
+	empty := models.Product{Name: "Lechuga", Measurement: models.Unidades}
+	after, factor, err := rebaseProduct(empty, models.Kilos)
+	if err != nil || factor != 1 || after.Measurement != models.Kilos {
+		t.Errorf("empty product = %v, %v, %v; want KILOS, 1, nil", after.Measurement, factor, err)
+	}
+}
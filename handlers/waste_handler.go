@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// roundRatio rounds a ratio to 4 decimals (hundredths of a percent), so that small waste
+// still shows up.
+func roundRatio(ratio float64) float64 {
+	return math.Round(ratio*10000) / 10000
+}
+
+// CreateWasteHandler registers waste (merma) of a product: the quantity goes out of the
+// stock as a waste movement, valued at the current cost of the product. Waste larger than
+// the stock is rejected; the stock has to be counted first.
+func CreateWasteHandler(c *gin.Context) {
+	productID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Quantity float64            `json:"quantity"`
+		Unit     models.Measurement `json:"unit"` // Por defecto la unidad base del producto
+		Reason   models.WasteReason `json:"reason" binding:"required"`
+		Notes    string             `json:"notes"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || !input.Reason.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Motivo inválido (rotten, damaged, theft o giveaway)"})
+		return
+	}
+	if input.Quantity <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "La cantidad debe ser mayor a cero"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	base, product, err := quantityInBase(ctx, storeID, productID, input.Quantity, input.Unit)
+	if errors.Is(err, errUnknownUnit) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "El producto no tiene configurada la unidad " + string(input.Unit)})
+		return
+	}
+	movement := models.StockMovement{
+		StoreID:     storeID,
+		ProductID:   productID,
+		Type:        models.MovementWaste,
+		Quantity:    -base,
+		UserID:      actingUserFromContext(c),
+		Reason:      strings.TrimSpace(input.Notes),
+		WasteReason: input.Reason,
+	}
+	if err == nil {
+		enteredAs(&movement, product, -input.Quantity, input.Unit)
+		movement, err = applyStockMovement(ctx, movement)
+	}
+	if errors.Is(err, errProductNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Producto no encontrado o no pertenece a la tienda"})
+		return
+	}
+	if errors.Is(err, errProductArchived) {
+		c.JSON(http.StatusConflict, gin.H{"error": "El producto está archivado: restauralo para modificarlo"})
+		return
+	}
+	if errors.Is(err, errInsufficientStock) {
+		c.JSON(http.StatusConflict, gin.H{"error": "La merma supera el stock: registrá primero un conteo"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al registrar merma"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, movement)
+}
+
+// wasteTotals is the wasted quantity and its value at cost.
+type wasteTotals struct {
+	Quantity float64 `json:"quantity"`
+	Value    float64 `json:"value"`
+}
+
+// wasteProductReport is the waste of one product in the period, split by reason.
+type wasteProductReport struct {
+	ProductID   primitive.ObjectID                  `json:"productId"`
+	ProductName string                              `json:"productName"`
+	Unit        models.Measurement                  `json:"unit"`
+	Quantity    float64                             `json:"quantity"`
+	Value       float64                             `json:"value"`
+	Purchased   float64                             `json:"purchased"`  // Lo que entró por compras en el mismo período
+	WasteRatio  float64                             `json:"wasteRatio"` // Merma / compras (0 si no hubo compras)
+	ByReason    map[models.WasteReason]*wasteTotals `json:"byReason"`
+}
+
+// GetWasteReportHandler summarizes waste by product and reason over a period (from/to),
+// valued at the cost recorded on each movement. Optional filters: productId, reason.
+// Products are sorted by lost value, so the ones we over-buy come first.
+func GetWasteReportHandler(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	match := bson.M{"storeId": storeID, "type": models.MovementWaste}
+	period := bson.M{"storeId": storeID, "type": models.MovementPurchase}
+	if hex := c.Query("productId"); hex != "" {
+		productID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ID de producto inválido"})
+			return
+		}
+		match["productId"] = productID
+		period["productId"] = productID
+	}
+	if reason := models.WasteReason(c.Query("reason")); reason != "" {
+		if !reason.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Motivo inválido"})
+			return
+		}
+		match["wasteReason"] = reason
+	}
+	createdAt, ok := dateRangeFilter(c)
+	if !ok {
+		return
+	}
+	if createdAt != nil {
+		match["createdAt"] = createdAt
+		period["createdAt"] = createdAt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Las mermas tienen cantidad negativa: la damos vuelta para el reporte
+	cursor, err := database.StockMovementsCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"productId": "$productId", "reason": "$wasteReason"},
+			"unit":     bson.M{"$last": "$unit"},
+			"quantity": bson.M{"$sum": bson.M{"$multiply": bson.A{"$quantity", -1}}},
+			"value":    bson.M{"$sum": bson.M{"$multiply": bson.A{"$quantity", -1, bson.M{"$ifNull": bson.A{"$unitCost", 0}}}}},
+		}}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al calcular mermas"})
+		return
+	}
+	var rows []struct {
+		ID struct {
+			ProductID primitive.ObjectID `bson:"productId"`
+			Reason    models.WasteReason `bson:"reason"`
+		} `bson:"_id"`
+		Unit     models.Measurement `bson:"unit"`
+		Quantity float64            `bson:"quantity"`
+		Value    float64            `bson:"value"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al procesar mermas"})
+		return
+	}
+
+	products := map[primitive.ObjectID]*wasteProductReport{}
+	byReason := map[models.WasteReason]float64{}
+	totalValue := 0.0
+	for _, row := range rows {
+		reason := row.ID.Reason
+		if reason == "" {
+			reason = "unspecified" // Mermas cargadas como movimiento genérico
+		}
+
+		report, ok := products[row.ID.ProductID]
+		if !ok {
+			report = &wasteProductReport{
+				ProductID: row.ID.ProductID,
+				Unit:      row.Unit,
+				ByReason:  map[models.WasteReason]*wasteTotals{},
+			}
+			products[row.ID.ProductID] = report
+		}
+		report.Quantity += row.Quantity
+		report.Value += row.Value
+		report.ByReason[reason] = &wasteTotals{Quantity: row.Quantity, Value: roundMoney(row.Value)}
+
+		byReason[reason] += row.Value
+		totalValue += row.Value
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(products))
+	for id := range products {
+		ids = append(ids, id)
+	}
+
+	if len(ids) > 0 {
+		// Nombres y unidad de los productos (al cambiar de unidad, el historial se convierte a la nueva)
+		nameCursor, err := database.StockCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		if err == nil {
+			var found []models.Product
+			if nameCursor.All(ctx, &found) == nil {
+				for _, p := range found {
+					products[p.ID].ProductName = p.Name
+					products[p.ID].Unit = p.Measurement
+				}
+			}
+		}
+
+		// Compras del mismo período para ver qué se compra de más
+		period["productId"] = bson.M{"$in": ids}
+		purchaseCursor, err := database.StockMovementsCollection.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$match", Value: period}},
+			{{Key: "$group", Value: bson.M{"_id": "$productId", "quantity": bson.M{"$sum": "$quantity"}}}},
+		})
+		if err == nil {
+			var purchases []struct {
+				ProductID primitive.ObjectID `bson:"_id"`
+				Quantity  float64            `bson:"quantity"`
+			}
+			if purchaseCursor.All(ctx, &purchases) == nil {
+				for _, p := range purchases {
+					products[p.ProductID].Purchased = p.Quantity
+				}
+			}
+		}
+	}
+
+	items := make([]*wasteProductReport, 0, len(products))
+	for _, report := range products {
+		report.Value = roundMoney(report.Value)
+		if report.Purchased > 0 {
+			report.WasteRatio = roundRatio(report.Quantity / report.Purchased)
+		}
+		items = append(items, report)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Value > items[j].Value })
+
+	for reason, value := range byReason {
+		byReason[reason] = roundMoney(value)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"totalValue": roundMoney(totalValue),
+		"byReason":   byReason,
+		"products":   items,
+	})
+}
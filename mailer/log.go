@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer no envía nada: escribe el email en el log o, si se indica, lo agrega a un archivo.
+// Sirve para desarrollo y para leer los links de reseteo en pruebas.
+type LogMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	entry := fmt.Sprintf("---- %s ----\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+
+	if m.path == "" {
+		log.Printf("📧 Email (no enviado):\n%s", entry)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(entry)
+	return err
+}
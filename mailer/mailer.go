@@ -0,0 +1,47 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// Message es un email de texto plano.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envía emails. Hay una implementación SMTP para producción y una
+// que escribe en el log (o en un archivo) para desarrollo y pruebas.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var defaultMailer Mailer = NewLogMailer("")
+
+// Load elige el mailer según MAILER ("smtp" o "log", por defecto "log").
+func Load() {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		m, err := NewSMTPMailerFromEnv()
+		if err != nil {
+			log.Fatal("❌ Error Fatal: configuración SMTP inválida: ", err)
+		}
+		defaultMailer = m
+	default:
+		defaultMailer = NewLogMailer(os.Getenv("MAIL_LOG_FILE"))
+		log.Println("ℹ️ Info: Los emails se escriben en el log (MAILER=log)")
+	}
+}
+
+// Set reemplaza el mailer por defecto.
+func Set(m Mailer) {
+	defaultMailer = m
+}
+
+// Send envía el mensaje con el mailer por defecto.
+func Send(ctx context.Context, msg Message) error {
+	return defaultMailer.Send(ctx, msg)
+}
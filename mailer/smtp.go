@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// SMTPMailer envía emails usando un servidor SMTP con autenticación PLAIN.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPMailerFromEnv lee SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD y MAIL_FROM.
+func NewSMTPMailerFromEnv() (*SMTPMailer, error) {
+	m := &SMTPMailer{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}
+	if m.Port == "" {
+		m.Port = "587"
+	}
+	if m.Host == "" || m.From == "" {
+		return nil, errors.New("SMTP_HOST y MAIL_FROM son requeridos")
+	}
+	return m, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	headers := []string{
+		"From: " + m.From,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + msg.Body
+
+	// smtp.SendMail no acepta contexto: lo corremos aparte y respetamos el timeout
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{msg.To}, []byte(body))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("error enviando email a %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
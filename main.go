@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"verdustock-auth/database"
 	"verdustock-auth/handlers"
+	"verdustock-auth/mailer"
 	"verdustock-auth/middleware"
+	"verdustock-auth/models"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -34,7 +37,8 @@ func main() {
 	}
 
 	// 2. Inicialización de Secretos y Base de Datos
-	middleware.LoadSecret()
+	middleware.LoadKeys()
+	mailer.Load()
 
 	mongoURI := os.Getenv("MONGODB_URI")
 	dbName := os.Getenv("MONGODB_NAME")
@@ -49,14 +53,44 @@ func main() {
 		log.Println("⚠️ Advertencia: No se pudo inicializar el catálogo de productos:", err)
 	}
 
+	if err := handlers.MigrateUsersToStores(); err != nil {
+		log.Println("⚠️ Advertencia: No se pudo migrar usuarios a tiendas:", err)
+	}
+
+	if err := handlers.MigrateOpeningBalances(); err != nil {
+		log.Println("⚠️ Advertencia: No se pudo migrar el saldo inicial del stock:", err)
+	}
+
+	if err := handlers.MarkExistingUsersVerified(); err != nil {
+		log.Println("⚠️ Advertencia: No se pudo marcar los usuarios existentes como verificados:", err)
+	}
+
+	if err := handlers.EnsureAdmins(); err != nil {
+		log.Println("⚠️ Advertencia: No se pudo configurar los administradores:", err)
+	}
+
 	// 3. Configuración del Servidor y CORS
 	router := gin.Default()
 
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{
-		"http://localhost:4200",       // Local
-		"https://kikixgabs.github.io", // Producción
+	// La IP del cliente se usa para limitar intentos, en sesiones y en la auditoría: solo
+	// confiamos en el header que pone la plataforma (Render está detrás de Cloudflare) o en
+	// los proxies configurados. Sin esto cualquiera falsifica X-Forwarded-For.
+	router.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM_HEADER")
+	if router.TrustedPlatform == "" && env == "production" {
+		router.TrustedPlatform = gin.PlatformCloudflare
 	}
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("❌ Error Fatal: TRUSTED_PROXIES inválido: ", err)
+	}
+
+	config := cors.DefaultConfig()
+	config.AllowOrigins = middleware.AllowedOrigins
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
 	config.AllowHeaders = []string{
 		"Origin",
@@ -64,7 +98,6 @@ func main() {
 		"Accept",
 		"Authorization",
 		"X-Requested-With",
-		"X-Admin-Secret",
 	}
 	config.AllowCredentials = true
 	config.MaxAge = 12 * time.Hour
@@ -72,46 +105,156 @@ func main() {
 	router.Use(cors.New(config))
 
 	// 4. Definición de Rutas
+	router.GET("/.well-known/jwks.json", handlers.JWKSHandler)
 	router.POST("/login", handlers.LoginHandler)
-	router.POST("/logout", handlers.LogoutHandler)
+	router.POST("/logout", middleware.CSRFProtect(), handlers.LogoutHandler)
+	router.POST("/auth/refresh", middleware.CSRFProtect(), handlers.RefreshHandler)
+	router.POST("/auth/login/mfa", handlers.LoginMFAHandler)
+	router.POST("/auth/login/mfa/enroll", handlers.LoginMFAEnrollHandler)
+	router.POST("/auth/forgot-password", handlers.ForgotPasswordHandler)
+	router.POST("/auth/reset-password", handlers.ResetPasswordHandler)
+	router.POST("/auth/accept-invitation", handlers.AcceptInvitationHandler)
+	router.POST("/auth/verify-email", handlers.VerifyEmailHandler)
+	router.POST("/auth/resend-verification", handlers.ResendVerificationHandler)
+
+	// Grupo Cuenta (Protegido, solo con sesión: no acepta API keys)
+	accountGroup := router.Group("/auth")
+	accountGroup.Use(middleware.AuthMiddleware(), middleware.RequireSession())
+	{
+		accountGroup.GET("/me", handlers.AuthMeHandler(database.UserCollection))
+		accountGroup.POST("/switch-user", handlers.SwitchUserHandler)
 
-	router.GET("/auth/me", handlers.AuthMeHandler(database.UserCollection))
-	router.POST("/admin/create-user", handlers.AdminCreateUserHandler)
+		// La configuración de la cuenta es del titular, no de quien esté usando la terminal
+		holderGroup := accountGroup.Group("")
+		holderGroup.Use(middleware.RequireAccountHolder())
+		holderGroup.PATCH("/me", handlers.UpdateProfileHandler)
+		holderGroup.POST("/change-password", handlers.ChangePasswordHandler)
+		holderGroup.POST("/mfa/enroll", handlers.EnrollMFAHandler)
+		holderGroup.POST("/mfa/confirm", handlers.ConfirmMFAHandler)
+		holderGroup.POST("/mfa/disable", handlers.DisableMFAHandler)
+		holderGroup.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodesHandler)
+		holderGroup.GET("/sessions", handlers.ListSessionsHandler)
+		holderGroup.DELETE("/sessions/:id", handlers.RevokeSessionHandler)
+		holderGroup.POST("/logout-all", handlers.LogoutAllHandler)
+	}
+
+	// Grupo Admin (Protegido, solo administradores de la plataforma)
+	adminGroup := router.Group("/admin")
+	adminGroup.Use(middleware.AuthMiddleware(), middleware.RequireAdmin())
+	{
+		adminGroup.GET("/users", handlers.AdminListUsersHandler)
+		adminGroup.POST("/users", handlers.AdminCreateUserHandler)
+		adminGroup.GET("/users/:id", handlers.AdminGetUserHandler)
+		adminGroup.POST("/users/:id/disable", handlers.AdminDisableUserHandler)
+		adminGroup.POST("/users/:id/enable", handlers.AdminEnableUserHandler)
+		adminGroup.POST("/users/:id/force-password-reset", handlers.AdminForcePasswordResetHandler)
+		adminGroup.DELETE("/users/:id", handlers.AdminDeleteUserHandler)
+	}
 
 	// Webhooks
 	router.POST("/webhooks/mercadopago", handlers.HandleMPWebhook)
 
 	// Rutas de Pagos y Caja (Protegidas)
-	router.GET("/payments", middleware.AuthMiddleware(), handlers.GetMPPaymentsHandler)
-	router.POST("/payments/sync", middleware.AuthMiddleware(), handlers.SyncMPTransfersHandler)
+	router.GET("/payments", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermPaymentsRead), handlers.GetMPPaymentsHandler)
+	router.POST("/payments/sync", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermPaymentsSync), handlers.SyncMPTransfersHandler)
 
 	// ✅ NUEVA RUTA: Verificar cajas pendientes (Solución al error 404)
-	router.GET("/cash/pending", middleware.AuthMiddleware(), handlers.CheckPendingBoxesHandler)
+	router.GET("/cash/pending", middleware.AuthMiddleware(), middleware.RequirePermission(models.PermCashRead), handlers.CheckPendingBoxesHandler)
 
 	// Grupo User (Protegido)
 	userGroup := router.Group("/user")
 	userGroup.Use(middleware.AuthMiddleware())
 	{
-		userGroup.POST("/mercadopago/link", handlers.LinkMPAccountHandler)
+		userGroup.POST("/mercadopago/link", middleware.RequirePermission(models.PermMPLink), handlers.LinkMPAccountHandler)
+	}
+
+	// Grupo Tienda (Protegido)
+	storeGroup := router.Group("/store")
+	storeGroup.Use(middleware.AuthMiddleware())
+	{
+		storeGroup.GET("", handlers.GetStoreHandler)
+		storeGroup.PATCH("", middleware.RequireRole(models.RoleOwner), handlers.UpdateStoreHandler)
+		storeGroup.PUT("/security", middleware.RequireRole(models.RoleOwner), handlers.UpdateStoreSecurityHandler)
+		storeGroup.GET("/members", middleware.RequireRole(models.RoleOwner), handlers.ListStoreMembersHandler)
+		storeGroup.PUT("/members/:id/role", middleware.RequireRole(models.RoleOwner), handlers.UpdateMemberRoleHandler)
+		storeGroup.PUT("/members/:id/pin", middleware.RequireRole(models.RoleOwner), handlers.SetMemberPINHandler)
+		storeGroup.DELETE("/members/:id/pin", middleware.RequireRole(models.RoleOwner), handlers.ClearMemberPINHandler)
+		storeGroup.POST("/price-lists", middleware.RequireRole(models.RoleOwner), handlers.CreatePriceListHandler)
+		storeGroup.DELETE("/price-lists/:code", middleware.RequireRole(models.RoleOwner), handlers.DeletePriceListHandler)
+		storeGroup.GET("/invitations", middleware.RequireRole(models.RoleOwner), handlers.ListInvitationsHandler)
+		storeGroup.POST("/invitations", middleware.RequireRole(models.RoleOwner), handlers.CreateInvitationHandler)
+		storeGroup.DELETE("/invitations/:id", middleware.RequireRole(models.RoleOwner), handlers.RevokeInvitationHandler)
+	}
+
+	// Auditoría de la tienda (solo el dueño)
+	router.GET("/audit", middleware.AuthMiddleware(), middleware.RequireRole(models.RoleOwner), handlers.GetAuditLogHandler)
+
+	// Grupo API Keys (Protegido, solo el dueño)
+	apiKeysGroup := router.Group("/api-keys")
+	apiKeysGroup.Use(middleware.AuthMiddleware(), middleware.RequireRole(models.RoleOwner))
+	{
+		apiKeysGroup.GET("", handlers.ListAPIKeysHandler)
+		apiKeysGroup.POST("", handlers.CreateAPIKeyHandler)
+		apiKeysGroup.DELETE("/:id", handlers.RevokeAPIKeyHandler)
 	}
 
 	// Grupo Stock (Protegido)
 	stockGroup := router.Group("/stock")
 	stockGroup.Use(middleware.AuthMiddleware())
 	{
-		stockGroup.GET("", handlers.GetStockHandler)
-		stockGroup.PUT("/:id", handlers.UpdateProductHandler)
-		stockGroup.POST("", handlers.CreateProductHandler)
+		stockGroup.GET("", middleware.RequirePermission(models.PermStockRead), handlers.GetStockHandler)
+		stockGroup.GET("/shopping-list", middleware.RequirePermission(models.PermStockRead), handlers.GetShoppingListHandler)
+		stockGroup.GET("/alerts", middleware.RequirePermission(models.PermStockRead), handlers.GetStockAlertsHandler)
+		stockGroup.POST("/alerts/:id/acknowledge", middleware.RequirePermission(models.PermStockWrite), handlers.AcknowledgeStockAlertHandler)
+		stockGroup.PUT("/:id", middleware.RequirePermission(models.PermStockWrite), handlers.UpdateProductHandler)
+		stockGroup.POST("", middleware.RequirePermission(models.PermStockWrite), handlers.CreateProductHandler)
+		stockGroup.DELETE("/:id", middleware.RequireRole(models.RoleOwner), handlers.DeleteProductHandler)
+		stockGroup.POST("/:id/archive", middleware.RequirePermission(models.PermStockWrite), handlers.ArchiveProductHandler)
+		stockGroup.POST("/:id/restore", middleware.RequirePermission(models.PermStockWrite), handlers.RestoreProductHandler)
+		stockGroup.GET("/:id/movements", middleware.RequirePermission(models.PermStockRead), handlers.GetStockMovementsHandler)
+		stockGroup.POST("/:id/movements", middleware.RequirePermission(models.PermStockWrite), handlers.CreateStockMovementHandler)
+		stockGroup.PUT("/:id/conversions", middleware.RequirePermission(models.PermStockWrite), handlers.SetConversionsHandler)
+		stockGroup.POST("/:id/waste", middleware.RequirePermission(models.PermStockWrite), handlers.CreateWasteHandler)
+		stockGroup.GET("/waste/report", middleware.RequirePermission(models.PermStockRead), handlers.GetWasteReportHandler)
+		stockGroup.POST("/prices/bulk", middleware.RequirePermission(models.PermPricesWrite), handlers.BulkPriceUpdateHandler)
+		stockGroup.POST("/prices/bulk/undo", middleware.RequirePermission(models.PermPricesWrite), handlers.UndoBulkPriceUpdateHandler)
+		stockGroup.GET("/:id/prices/history", middleware.RequirePermission(models.PermStockRead), handlers.GetPriceHistoryHandler)
+		stockGroup.GET("/:id/supplier-prices", middleware.RequirePermission(models.PermPurchasesRead), handlers.GetProductSupplierPricesHandler)
+	}
+
+	// Grupo Compras (Protegido)
+	purchasesGroup := router.Group("/purchases")
+	purchasesGroup.Use(middleware.AuthMiddleware())
+	{
+		purchasesGroup.GET("", middleware.RequirePermission(models.PermPurchasesRead), handlers.GetReceiptsHandler)
+		purchasesGroup.POST("", middleware.RequirePermission(models.PermPurchasesWrite), handlers.CreateReceiptHandler)
+		purchasesGroup.GET("/:id", middleware.RequirePermission(models.PermPurchasesRead), handlers.GetReceiptHandler)
+		purchasesGroup.PUT("/:id", middleware.RequirePermission(models.PermPurchasesWrite), handlers.UpdateReceiptHandler)
+		purchasesGroup.POST("/:id/confirm", middleware.RequirePermission(models.PermPurchasesWrite), handlers.ConfirmReceiptHandler)
+		purchasesGroup.POST("/:id/cancel", middleware.RequirePermission(models.PermPurchasesWrite), handlers.CancelReceiptHandler)
+	}
+
+	// Grupo Proveedores (Protegido)
+	suppliersGroup := router.Group("/suppliers")
+	suppliersGroup.Use(middleware.AuthMiddleware())
+	{
+		suppliersGroup.GET("", middleware.RequirePermission(models.PermPurchasesRead), handlers.GetSuppliersHandler)
+		suppliersGroup.POST("", middleware.RequirePermission(models.PermPurchasesWrite), handlers.CreateSupplierHandler)
+		suppliersGroup.GET("/:id", middleware.RequirePermission(models.PermPurchasesRead), handlers.GetSupplierHandler)
+		suppliersGroup.PUT("/:id", middleware.RequirePermission(models.PermPurchasesWrite), handlers.UpdateSupplierHandler)
+		suppliersGroup.DELETE("/:id", middleware.RequireRole(models.RoleOwner), handlers.DeleteSupplierHandler)
+		suppliersGroup.PUT("/:id/products/:productId", middleware.RequirePermission(models.PermPurchasesWrite), handlers.LinkSupplierProductHandler)
+		suppliersGroup.DELETE("/:id/products/:productId", middleware.RequirePermission(models.PermPurchasesWrite), handlers.UnlinkSupplierProductHandler)
 	}
 
 	// Grupo Ventas (Protegido)
 	sellsGroup := router.Group("/sells")
 	sellsGroup.Use(middleware.AuthMiddleware())
 	{
-		sellsGroup.POST("", handlers.CreateSellHandler)
-		sellsGroup.GET("", handlers.GetSellsHandler)
-		sellsGroup.PUT("/:id", handlers.UpdateSellHandler)
-		sellsGroup.POST("/close", handlers.CloseBoxHandler)
+		sellsGroup.POST("", middleware.RequirePermission(models.PermSellsWrite), handlers.CreateSellHandler)
+		sellsGroup.GET("", middleware.RequirePermission(models.PermSellsRead), handlers.GetSellsHandler)
+		sellsGroup.PUT("/:id", middleware.RequirePermission(models.PermSellsWrite), handlers.UpdateSellHandler)
+		sellsGroup.POST("/close", middleware.RequirePermission(models.PermCashClose), handlers.CloseBoxHandler)
 	}
 
 	// 5. Iniciar Servidor
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	APIKeyPrefix = "vsk_"
+
+	AuthMethodSession = "session"
+	AuthMethodAPIKey  = "api_key"
+)
+
+var ErrInvalidAPIKey = errors.New("API key inválida")
+
+// GenerateAPIKey returns a new key with the form vsk_<prefix>_<secret>, its prefix and its hash.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	prefixBytes, err := GenerateRandomToken(6)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// El prefijo no puede tener "_" porque es el separador
+	prefix = strings.ReplaceAll(prefixBytes, "_", "x")
+	key = APIKeyPrefix + prefix + "_" + secret
+	return key, prefix, HashToken(key), nil
+}
+
+// parseAPIKeyPrefix extracts the public prefix of a key.
+func parseAPIKeyPrefix(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(key, APIKeyPrefix)
+	prefix, secret, found := strings.Cut(rest, "_")
+	if !found || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// lookupAPIKey finds an active key and checks it against its stored hash.
+func lookupAPIKey(ctx context.Context, key string) (models.APIKey, error) {
+	var apiKey models.APIKey
+
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return apiKey, ErrInvalidAPIKey
+	}
+	if err := database.APIKeysCollection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&apiKey); err != nil {
+		return apiKey, ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(HashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return apiKey, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && apiKey.ExpiresAt.Before(now)) {
+		return apiKey, ErrInvalidAPIKey
+	}
+	return apiKey, nil
+}
+
+// authenticateAPIKey validates an "Authorization: ApiKey ..." header and fills the context
+// like a session would. The key acts with the role of whoever created it, limited by its scopes.
+func authenticateAPIKey(c *gin.Context, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	apiKey, err := lookupAPIKey(ctx, key)
+	if err != nil {
+		abortUnauthorized(c, "API key inválida, vencida o revocada", "")
+		return
+	}
+
+	var creator models.User
+	err = database.UserCollection.FindOne(ctx, bson.M{"_id": apiKey.CreatedBy, "storeId": apiKey.StoreID}).Decode(&creator)
+	if err != nil {
+		abortUnauthorized(c, "El creador de la API key ya no pertenece a la tienda", "")
+		return
+	}
+	if creator.Disabled {
+		abortUnauthorized(c, "La cuenta del creador de la API key está deshabilitada", "")
+		return
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > lastSeenInterval {
+		_, _ = database.APIKeysCollection.UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+	}
+
+	c.Set("userId", apiKey.CreatedBy.Hex())
+	c.Set("storeId", apiKey.StoreID.Hex())
+	c.Set("role", string(creator.GetRole()))
+	c.Set("authMethod", AuthMethodAPIKey)
+	c.Set("apiKey", apiKey)
+	c.Next()
+}
+
+// AuthMethod returns how the request was authenticated (session or api_key).
+func AuthMethod(c *gin.Context) string {
+	return c.GetString("authMethod")
+}
+
+// CurrentAPIKey returns the API key used by the request, if any.
+func CurrentAPIKey(c *gin.Context) (models.APIKey, bool) {
+	value, exists := c.Get("apiKey")
+	if !exists {
+		return models.APIKey{}, false
+	}
+	apiKey, ok := value.(models.APIKey)
+	return apiKey, ok
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AllowedOrigins is the frontend allowlist, shared by CORS and the CSRF check.
+var AllowedOrigins = []string{
+	"http://localhost:4200",       // Local
+	"https://kikixgabs.github.io", // Producción
+}
+
+// isSafeMethod reports whether the method cannot change state.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+func isAllowedOrigin(origin string) bool {
+	for _, allowed := range AllowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// requestOrigin returns the Origin header or, when the browser omitted it, the origin of the Referer.
+func requestOrigin(c *gin.Context) string {
+	if origin := c.GetHeader("Origin"); origin != "" {
+		return origin
+	}
+	referer, err := url.Parse(c.GetHeader("Referer"))
+	if err != nil || referer.Scheme == "" || referer.Host == "" {
+		return ""
+	}
+	return referer.Scheme + "://" + referer.Host
+}
+
+// checkCSRF rejects state-changing requests authenticated by cookie that do not come from
+// our frontend. The cookies are SameSite=None in production, so the browser would send
+// them on a cross-site form post; a request without Origin or Referer is rejected too.
+func checkCSRF(c *gin.Context) bool {
+	if isSafeMethod(c.Request.Method) || isAllowedOrigin(requestOrigin(c)) {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Origen de la solicitud no permitido", "code": "CSRF_REJECTED"})
+	return false
+}
+
+// CSRFProtect applies the same check on routes that read the session cookies directly
+// (refresh, logout) instead of going through AuthMiddleware.
+func CSRFProtect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasRefreshCookie(c) && !hasAccessCookie(c) {
+			c.Next()
+			return
+		}
+		if !checkCSRF(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasAccessCookie(c *gin.Context) bool {
+	value, err := c.Cookie(AccessCookieName)
+	return err == nil && value != ""
+}
@@ -1,104 +1,192 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var secretKey []byte
-
-func LoadSecret() {
-	secretKey = []byte(os.Getenv("JWT_SECRET"))
-}
-
-func GetSecret() []byte {
-	return secretKey
-}
-
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var tokenString string
-		var err error
 
 		// 1. INTENTO PRINCIPAL: Buscar en el Header "Authorization" (Estándar para Apps Web)
 		authHeader := c.GetHeader("Authorization")
 		if authHeader != "" {
-			// El formato debe ser "Bearer <token>"
+			// El formato debe ser "Bearer <token>" o, para integraciones, "ApiKey <clave>"
 			parts := strings.Split(authHeader, " ")
 			if len(parts) == 2 && parts[0] == "Bearer" {
 				tokenString = parts[1]
 			}
+			if len(parts) == 2 && parts[0] == "ApiKey" {
+				authenticateAPIKey(c, parts[1])
+				return
+			}
 		}
 
 		// 2. INTENTO SECUNDARIO: Si no hay header, buscar en Cookie (Fallback)
 		if tokenString == "" {
-			tokenString, err = c.Cookie("token")
+			tokenString, _ = c.Cookie(AccessCookieName)
+
+			// El navegador manda la cookie solo: hay que verificar que el request venga de nuestro front
+			if tokenString != "" && !checkCSRF(c) {
+				return
+			}
 		}
 
-		// Si fallaron los dos métodos, abortar
-		if tokenString == "" || err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No autorizado: token ausente"})
-			c.Abort()
+		// Si fallaron los dos métodos, abortar.
+		// Si todavía hay refresh token, avisamos al front que puede renovar la sesión.
+		if tokenString == "" {
+			if hasRefreshCookie(c) {
+				abortUnauthorized(c, "Token expirado", "TOKEN_EXPIRED")
+				return
+			}
+			abortUnauthorized(c, "No autorizado: token ausente", "")
 			return
 		}
 
-		// Parsear y validar el token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return GetSecret(), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token inválido o expirado"})
-			c.Abort()
+		claims, err := ParseAccessToken(tokenString)
+		if err != nil {
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				abortUnauthorized(c, "Token expirado", "TOKEN_EXPIRED")
+				return
+			}
+			abortUnauthorized(c, "Token inválido o expirado", "")
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Claims inválidos"})
-			c.Abort()
+		userID, err := primitive.ObjectIDFromHex(claims.UserID)
+		if err != nil {
+			abortUnauthorized(c, "Formato de userId inválido", "")
 			return
 		}
-
-		var userIDStr string
-		if v, ok := claims["userId"].(string); ok && v != "" {
-			userIDStr = v
-		} else if v, ok := claims["userID"].(string); ok && v != "" {
-			userIDStr = v
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "userId inválido"})
-			c.Abort()
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			abortUnauthorized(c, "Sesión inválida", "")
 			return
 		}
 
-		if _, err := primitive.ObjectIDFromHex(userIDStr); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Formato de userId inválido"})
-			c.Abort()
+		// La firma no alcanza: la sesión tiene que seguir activa en la base
+		if !sessionIsActive(c, sessionID, userID) {
+			abortUnauthorized(c, "Sesión revocada o expirada", "SESSION_REVOKED")
 			return
 		}
 
-		c.Set("userId", userIDStr)
+		c.Set("userId", claims.UserID)
+		c.Set("sessionId", claims.SessionID)
+		c.Set("storeId", claims.StoreID)
+		c.Set("role", string(claims.Role))
+		c.Set("actingUserId", claims.ActingUserID)
+		c.Set("authMethod", AuthMethodSession)
 		c.Next()
 	}
 }
 
-// Corrección de la Cookie
+// ActingUserID returns who is operating right now: the member switched in with a PIN
+// on a shared terminal or, by default, the session holder (userId).
+func ActingUserID(c *gin.Context) string {
+	if acting := c.GetString("actingUserId"); acting != "" {
+		return acting
+	}
+	return c.GetString("userId")
+}
+
+// IsSwitchedUser reports whether a member other than the session holder is operating.
+func IsSwitchedUser(c *gin.Context) bool {
+	return ActingUserID(c) != c.GetString("userId")
+}
+
+// Cada cuánto actualizamos lastSeenAt, para no escribir en Mongo en cada request
+const lastSeenInterval = time.Minute
+
+func sessionIsActive(c *gin.Context, sessionID, userID primitive.ObjectID) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := database.SessionsCollection.FindOne(ctx, bson.M{"_id": sessionID, "userId": userID}).Decode(&session)
+	if err != nil {
+		return false
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+		return false
+	}
+
+	// Una cuenta deshabilitada por un admin no puede seguir operando
+	if !userIsEnabled(ctx, userID) {
+		return false
+	}
+
+	if now.Sub(session.LastSeenAt) > lastSeenInterval {
+		_, _ = database.SessionsCollection.UpdateOne(ctx,
+			bson.M{"_id": sessionID},
+			bson.M{"$set": bson.M{"lastSeenAt": now, "ip": c.ClientIP()}},
+		)
+	}
+	return true
+}
+
+func userIsEnabled(ctx context.Context, userID primitive.ObjectID) bool {
+	count, err := database.UserCollection.CountDocuments(ctx,
+		bson.M{"_id": userID, "disabled": bson.M{"$ne": true}},
+		options.Count().SetLimit(1),
+	)
+	return err == nil && count == 1
+}
+
+func abortUnauthorized(c *gin.Context, message, code string) {
+	body := gin.H{"error": message}
+	if code != "" {
+		body["code"] = code
+	}
+	c.AbortWithStatusJSON(http.StatusUnauthorized, body)
+}
+
+func hasRefreshCookie(c *gin.Context) bool {
+	value, err := c.Cookie(RefreshCookieName)
+	return err == nil && value != ""
+}
+
+// SetAuthCookie guarda el access token en su cookie.
 func SetAuthCookie(c *gin.Context, tokenString string, duration time.Duration) {
+	setCookie(c, AccessCookieName, tokenString, int(duration.Seconds()))
+}
+
+// SetRefreshCookie guarda el refresh token opaco de la sesión.
+func SetRefreshCookie(c *gin.Context, tokenString string, duration time.Duration) {
+	setCookie(c, RefreshCookieName, tokenString, int(duration.Seconds()))
+}
+
+// ClearAuthCookies borra ambas cookies (access y refresh).
+func ClearAuthCookies(c *gin.Context) {
+	setCookie(c, AccessCookieName, "", -1)
+	setCookie(c, RefreshCookieName, "", -1)
+}
+
+// Corrección de la Cookie
+func setCookie(c *gin.Context, name, value string, maxAge int) {
 	env := os.Getenv("APP_ENV")
-	maxAge := int(duration.Seconds())
 
 	if env == "production" {
 		// EN PRODUCCIÓN: Construcción manual para incluir "Partitioned"
 		// Esto elimina el warning de Chrome y asegura compatibilidad futura (CHIPS).
 		// Formato: Name=Value; Path=/; Max-Age=N; HttpOnly; Secure; SameSite=None; Partitioned
-		cookieValue := fmt.Sprintf("token=%s; Path=/; Max-Age=%d; HttpOnly; Secure; SameSite=None; Partitioned", tokenString, maxAge)
+		cookieValue := fmt.Sprintf("%s=%s; Path=/; Max-Age=%d; HttpOnly; Secure; SameSite=None; Partitioned", name, value, maxAge)
 
 		// Usamos "Add" para setear el header directamente
 		c.Writer.Header().Add("Set-Cookie", cookieValue)
@@ -106,6 +194,6 @@ func SetAuthCookie(c *gin.Context, tokenString string, duration time.Duration) {
 		// EN DESARROLLO (Localhost): Usamos el método estándar de Gin
 		// Aquí no necesitamos Partitioned ni Secure estricto
 		c.SetSameSite(http.SameSiteLaxMode)
-		c.SetCookie("token", tokenString, maxAge, "/", "", false, true)
+		c.SetCookie(name, value, maxAge, "/", "", false, true)
 	}
 }
@@ -0,0 +1,216 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Los tokens se firman con EdDSA (Ed25519). Cada clave tiene un "kid" que viaja en el
+// header del JWT, así se pueden verificar tokens firmados con claves anteriores.
+//
+// Configuración:
+//   - JWT_KEYS_DIR: carpeta con las claves. "<kid>.pem" es una clave privada (PKCS#8) y
+//     "<kid>.pub.pem" una clave pública (PKIX) que solo sirve para verificar.
+//   - JWT_ACTIVE_KID: kid de la clave privada con la que se firman los tokens nuevos.
+//
+// Rotación (sin cortar sesiones):
+//  1. Generar la clave nueva:  openssl genpkey -algorithm ed25519 -out <kid>.pem
+//  2. Copiarla a JWT_KEYS_DIR y reiniciar: queda publicada en /.well-known/jwks.json
+//     pero todavía no firma. Esperar a que los otros servicios refresquen su caché del JWKS.
+//  3. Cambiar JWT_ACTIVE_KID al kid nuevo y reiniciar.
+//  4. Reemplazar "<kid>.pem" de la clave vieja por "<kid>.pub.pem" (solo verifica) y,
+//     pasado InviteTokenTTL (el link más largo que firmamos), borrarla y reiniciar.
+//
+// En desarrollo, si no hay JWT_KEYS_DIR, se genera una clave efímera en cada arranque.
+
+const signingAlgorithm = "EdDSA"
+
+type signingKey struct {
+	kid     string
+	private ed25519.PrivateKey // nil si la clave es solo de verificación
+	public  ed25519.PublicKey
+}
+
+var (
+	keysMu        sync.RWMutex
+	activeKey     *signingKey
+	verifyKeys    = map[string]*signingKey{}
+	errNoKey      = errors.New("no hay clave de firma configurada")
+	errUnknownKID = errors.New("kid desconocido")
+)
+
+// LoadKeys reads the signing and verification keys from JWT_KEYS_DIR (see the comment above).
+func LoadKeys() {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("APP_ENV") == "production" {
+			log.Fatal("❌ Error Fatal: JWT_KEYS_DIR no está definida")
+		}
+		key, err := generateEphemeralKey()
+		if err != nil {
+			log.Fatal("❌ Error Fatal: no se pudo generar la clave JWT: ", err)
+		}
+		setKeys(key, map[string]*signingKey{key.kid: key})
+		log.Println("⚠️ Aviso: JWT_KEYS_DIR no definida, usando una clave efímera (las sesiones no sobreviven un reinicio)")
+		return
+	}
+
+	keys, err := readKeysDir(dir)
+	if err != nil {
+		log.Fatal("❌ Error Fatal: no se pudieron leer las claves JWT: ", err)
+	}
+
+	kid := os.Getenv("JWT_ACTIVE_KID")
+	active, ok := keys[kid]
+	if !ok || active.private == nil {
+		log.Fatalf("❌ Error Fatal: JWT_ACTIVE_KID=%q no corresponde a una clave privada en %s", kid, dir)
+	}
+
+	setKeys(active, keys)
+	log.Printf("✅ Claves JWT cargadas: activa %s, %d para verificar", kid, len(keys))
+}
+
+func setKeys(active *signingKey, keys map[string]*signingKey) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	activeKey = active
+	verifyKeys = keys
+}
+
+func generateEphemeralKey() (*signingKey, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := GenerateRandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: "dev-" + kid, private: private, public: public}, nil
+}
+
+// readKeysDir loads every "<kid>.pem" (private) and "<kid>.pub.pem" (public) file of the folder.
+func readKeysDir(dir string) (map[string]*signingKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*signingKey{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no es un archivo PEM", file)
+		}
+
+		name := filepath.Base(file)
+		if kid := strings.TrimSuffix(name, ".pub.pem"); kid != name {
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			public, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("%s: la clave no es Ed25519", file)
+			}
+			// Si también está la privada, manda la privada
+			if _, exists := keys[kid]; !exists {
+				keys[kid] = &signingKey{kid: kid, public: public}
+			}
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, ".pem")
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: la clave no es Ed25519", file)
+		}
+		keys[kid] = &signingKey{kid: kid, private: private, public: private.Public().(ed25519.PublicKey)}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no hay claves en %s", dir)
+	}
+	return keys, nil
+}
+
+// signToken signs the claims with the active key and sets its kid in the header.
+func signToken(claims jwt.Claims) (string, error) {
+	keysMu.RLock()
+	key := activeKey
+	keysMu.RUnlock()
+	if key == nil {
+		return "", errNoKey
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// parseToken verifies a token signed by any known key. The algorithm is pinned to EdDSA,
+// so tokens with "none", HS256 or any other alg are rejected before looking at the key.
+func parseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		keysMu.RLock()
+		key, ok := verifyKeys[kid]
+		keysMu.RUnlock()
+		if !ok {
+			return nil, errUnknownKID
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{signingAlgorithm}), jwt.WithExpirationRequired())
+}
+
+// JWK is a public key in JSON Web Key format (RFC 8037 for Ed25519).
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+}
+
+// PublicJWKS returns every verification key, so other services can validate our tokens.
+func PublicJWKS() []JWK {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+
+	jwks := []JWK{}
+	for _, key := range verifyKeys {
+		jwks = append(jwks, JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(key.public),
+			Kid: key.kid,
+			Alg: signingAlgorithm,
+			Use: "sig",
+		})
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].Kid < jwks[j].Kid })
+	return jwks
+}
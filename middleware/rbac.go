@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"verdustock-auth/database"
+	"verdustock-auth/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CurrentRole returns the role set by AuthMiddleware.
+func CurrentRole(c *gin.Context) models.Role {
+	return models.Role(c.GetString("role"))
+}
+
+// RequireRole only lets through users with one of the given roles.
+// Must run after AuthMiddleware.
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Las rutas por rol son de administración: no se delegan a API keys
+		if AuthMethod(c) == AuthMethodAPIKey {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Esta acción no está disponible con API key"})
+			return
+		}
+
+		current := CurrentRole(c)
+		for _, role := range roles {
+			if current == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No tenés permisos para realizar esta acción"})
+	}
+}
+
+// HasPermission reports whether the request may use the permission: the role grants it and,
+// with an API key, the key has it among its scopes. For checks that depend on the body.
+func HasPermission(c *gin.Context, perm models.Permission) bool {
+	if !CurrentRole(c).Can(perm) {
+		return false
+	}
+	if apiKey, ok := CurrentAPIKey(c); ok && !apiKey.HasScope(perm) {
+		return false
+	}
+	return true
+}
+
+// RequirePermission only lets through users whose role grants the permission.
+// Requests made with an API key also need the permission among the key scopes.
+// Must run after AuthMiddleware.
+func RequirePermission(perm models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CurrentRole(c).Can(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "No tenés permisos para realizar esta acción"})
+			return
+		}
+		if apiKey, ok := CurrentAPIKey(c); ok && !apiKey.HasScope(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "La API key no tiene el permiso " + string(perm)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects API keys: the route needs a logged in person (account, sessions, MFA...).
+// Must run after AuthMiddleware.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AuthMethod(c) != AuthMethodSession {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Esta acción requiere iniciar sesión"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAccountHolder rejects requests made while another member is switched in on the
+// terminal: account settings (password, MFA, sessions) belong to the session holder.
+// Must run after AuthMiddleware.
+func RequireAccountHolder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsSwitchedUser(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Volvé a tu usuario para administrar la cuenta"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin only lets through platform administrators logged in with a session.
+// The flag is read from the database on every request so revoking it is immediate.
+// Must run after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if AuthMethod(c) != AuthMethodSession || IsSwitchedUser(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Acceso denegado"})
+			return
+		}
+
+		userID, err := primitive.ObjectIDFromHex(c.GetString("userId"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Acceso denegado"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		count, err := database.UserCollection.CountDocuments(ctx, bson.M{"_id": userID, "isAdmin": true, "disabled": bson.M{"$ne": true}})
+		if err != nil || count == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Acceso denegado"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"verdustock-auth/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Duración del access token. Es corta a propósito: la sesión larga vive en el refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+const (
+	AccessCookieName  = "token"
+	RefreshCookieName = "refresh_token"
+
+	TokenTypeAccess      = "access"
+	TokenTypeMFAPending  = "mfa_pending"
+	TokenTypeInvite      = "invite"
+	TokenTypeEmailVerify = "email_verify"
+
+	// Tiempo para completar el segundo paso del login
+	MFATokenTTL = 5 * time.Minute
+
+	// Vigencia de los links que se mandan por email
+	InviteTokenTTL      = 7 * 24 * time.Hour
+	EmailVerifyTokenTTL = 48 * time.Hour
+)
+
+var ErrInvalidTokenType = errors.New("tipo de token inválido")
+
+// AccessClaims are the claims carried by every access token.
+// ActingUserID is set when another member switched in with a PIN; Role is then the acting user's role.
+type AccessClaims struct {
+	UserID       string      `json:"userId"`
+	SessionID    string      `json:"sid"`
+	StoreID      string      `json:"storeId"`
+	Role         models.Role `json:"role"`
+	ActingUserID string      `json:"act,omitempty"`
+	Type         string      `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// IssueAccessToken signs a short-lived access token bound to a session.
+// actingUserID may be empty when the session holder is the one operating.
+func IssueAccessToken(userID, sessionID, storeID string, role models.Role, actingUserID string) (string, error) {
+	if actingUserID == userID {
+		actingUserID = ""
+	}
+
+	now := time.Now()
+	claims := AccessClaims{
+		UserID:       userID,
+		SessionID:    sessionID,
+		StoreID:      storeID,
+		Role:         role,
+		ActingUserID: actingUserID,
+		Type:         TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ParseAccessToken validates the signature, expiration and type of an access token.
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	token, err := parseToken(tokenString, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.Type != TokenTypeAccess || claims.UserID == "" || claims.SessionID == "" || claims.StoreID == "" || !claims.Role.Valid() {
+		return nil, ErrInvalidTokenType
+	}
+	return claims, nil
+}
+
+// MFAClaims are carried by the "mfa pending" token issued after a correct password
+// when a second factor is still needed. It does not grant access to anything else.
+type MFAClaims struct {
+	UserID     string `json:"userId"`
+	RememberMe bool   `json:"rememberMe"`
+	Device     string `json:"device,omitempty"`
+	Enroll     bool   `json:"enroll,omitempty"` // El usuario todavía tiene que enrolarse
+	Type       string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// IssueMFAToken signs a short-lived token for the second login step.
+func IssueMFAToken(userID string, rememberMe bool, device string, enroll bool) (string, error) {
+	now := time.Now()
+	claims := MFAClaims{
+		UserID:     userID,
+		RememberMe: rememberMe,
+		Device:     device,
+		Enroll:     enroll,
+		Type:       TokenTypeMFAPending,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFATokenTTL)),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ParseMFAToken validates an "mfa pending" token.
+func ParseMFAToken(tokenString string) (*MFAClaims, error) {
+	claims := &MFAClaims{}
+	token, err := parseToken(tokenString, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.Type != TokenTypeMFAPending || claims.UserID == "" {
+		return nil, ErrInvalidTokenType
+	}
+	return claims, nil
+}
+
+// LinkClaims are carried by the tokens sent in emailed links (invitations, email
+// verification). Subject is the invitation or user ID the link acts on.
+type LinkClaims struct {
+	Email string `json:"email"`
+	Type  string `json:"typ"`
+	jwt.RegisteredClaims
+}
+
+// IssueLinkToken signs a token of the given type for an emailed link.
+func IssueLinkToken(tokenType, subject, email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := LinkClaims{
+		Email: email,
+		Type:  tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return signToken(claims)
+}
+
+// ParseLinkToken validates a link token and checks that it is of the expected type.
+func ParseLinkToken(tokenType, tokenString string) (*LinkClaims, error) {
+	claims := &LinkClaims{}
+	token, err := parseToken(tokenString, claims)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	if claims.Type != tokenType || claims.Subject == "" || claims.Email == "" {
+		return nil, ErrInvalidTokenType
+	}
+	return claims, nil
+}
+
+// GenerateRandomToken returns a URL-safe random string built from n random bytes.
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of an opaque token. Only hashes are stored in Mongo.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
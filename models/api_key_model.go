@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permisos que se pueden delegar a una API key (integraciones: balanza, etiquetas, planillas)
+var APIKeyScopes = []Permission{
+	PermStockRead,
+	PermStockWrite,
+	PermSellsRead,
+	PermSellsWrite,
+	PermPaymentsRead,
+	PermPurchasesRead,
+}
+
+// APIKey permite a scripts e integraciones llamar a la API sin cookie.
+// La clave completa se muestra una sola vez; se guarda solo su hash y un prefijo para identificarla.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StoreID    primitive.ObjectID `bson:"storeId" json:"storeId"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Scopes     []Permission       `bson:"scopes" json:"scopes"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the key was granted the permission.
+func (k APIKey) HasScope(p Permission) bool {
+	for _, s := range k.Scopes {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
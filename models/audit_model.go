@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Acciones registradas en la auditoría
+const (
+	AuditLogin         = "auth.login"
+	AuditLoginFailed   = "auth.login_failed"
+	AuditLoginLockout  = "auth.lockout"
+	AuditUserSwitch    = "auth.switch_user"
+	AuditUserCreated   = "admin.user_created"
+	AuditUserDisabled  = "admin.user_disabled"
+	AuditUserEnabled   = "admin.user_enabled"
+	AuditPasswordReset = "admin.password_reset_forced"
+	AuditUserDeleted   = "admin.user_deleted"
+	AuditInviteCreated = "store.invitation_created"
+	AuditInviteRevoked = "store.invitation_revoked"
+	AuditInviteUsed    = "store.invitation_accepted"
+	AuditMPLinked      = "mercadopago.linked"
+	AuditBoxClosed     = "cash.box_closed"
+)
+
+// AuditEntry es un registro de solo escritura de un evento de seguridad.
+type AuditEntry struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	StoreID    *primitive.ObjectID    `bson:"storeId,omitempty" json:"storeId,omitempty"`
+	ActorID    *primitive.ObjectID    `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	ActorEmail string                 `bson:"actorEmail,omitempty" json:"actorEmail,omitempty"`
+	Action     string                 `bson:"action" json:"action"`
+	TargetType string                 `bson:"targetType,omitempty" json:"targetType,omitempty"`
+	TargetID   string                 `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	IP         string                 `bson:"ip" json:"ip"`
+	UserAgent  string                 `bson:"userAgent" json:"userAgent"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt  time.Time              `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invitation es la invitación de un dueño para que un email se sume a su tienda.
+// El link que recibe el invitado es un token firmado que apunta a este documento;
+// acá queda el estado (pendiente, aceptada o revocada).
+type Invitation struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID    primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	Email      string              `bson:"email" json:"email"`
+	Role       Role                `bson:"role" json:"role"`
+	InvitedBy  primitive.ObjectID  `bson:"invitedBy" json:"invitedBy"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time           `bson:"expiresAt" json:"expiresAt"`
+	AcceptedAt *time.Time          `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+	AcceptedBy *primitive.ObjectID `bson:"acceptedBy,omitempty" json:"acceptedBy,omitempty"`
+	RevokedAt  *time.Time          `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginAttempt cuenta los logins fallidos por cuenta ("email:...") o por IP ("ip:...").
+// Vive en Mongo para que los bloqueos sobrevivan a los reinicios del servidor.
+type LoginAttempt struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key           string             `bson:"key" json:"key"`
+	Failures      int                `bson:"failures" json:"failures"`
+	Lockouts      int                `bson:"lockouts" json:"lockouts"`
+	LastFailureAt time.Time          `bson:"lastFailureAt" json:"lastFailureAt"`
+	LockedUntil   *time.Time         `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+}
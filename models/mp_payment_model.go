@@ -8,7 +8,7 @@ import (
 
 type MPPayment struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	StoreID     primitive.ObjectID `bson:"storeId" json:"storeId"`
 	MPPaymentID int64              `bson:"mpPaymentId" json:"mpPaymentId"`
 	Amount      float64            `bson:"amount" json:"amount"`
 	PayerEmail  string             `bson:"payerEmail" json:"payerEmail"`
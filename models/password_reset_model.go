@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PasswordReset es un token de un solo uso para restablecer la contraseña.
+// Solo se guarda el hash: el token en claro viaja únicamente en el email.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	TokenHash string             `bson:"tokenHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	RequestIP string             `bson:"requestIp" json:"requestIp"`
+}
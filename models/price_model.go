@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceList es una lista de precios de venta de la tienda. El código es la clave en Product.ListPrices.
+type PriceList struct {
+	Code string `bson:"code" json:"code"`
+	Name string `bson:"name" json:"name"`
+}
+
+type PriceKind string
+
+const (
+	PriceKindCost PriceKind = "cost"
+	PriceKindSale PriceKind = "sale"
+	PriceKindList PriceKind = "list" // Precio de una lista de Store.PriceLists
+)
+
+// Origen de un cambio de precio
+const (
+	PriceSourceManual = "manual"
+	PriceSourceBulk   = "bulk"
+)
+
+// PriceChange es un cambio de costo o precio de venta de un producto.
+type PriceChange struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID     primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	ProductID   primitive.ObjectID  `bson:"productId" json:"productId"`
+	ProductName string              `bson:"productName" json:"productName"`
+	Kind        PriceKind           `bson:"kind" json:"kind"`
+	PriceList   string              `bson:"priceList,omitempty" json:"priceList,omitempty"` // Solo para Kind "list"
+	OldPrice    float64             `bson:"oldPrice" json:"oldPrice"`
+	NewPrice    float64             `bson:"newPrice" json:"newPrice"`
+	UserID      primitive.ObjectID  `bson:"userId" json:"userId"`
+	Source      string              `bson:"source" json:"source"`
+	OperationID *primitive.ObjectID `bson:"operationId,omitempty" json:"operationId,omitempty"` // Actualización masiva que lo generó
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// BulkPriceItem es el cambio de un producto dentro de una actualización masiva.
+type BulkPriceItem struct {
+	ProductID   primitive.ObjectID `bson:"productId" json:"productId"`
+	ProductName string             `bson:"productName" json:"productName"`
+	OldPrice    float64            `bson:"oldPrice" json:"oldPrice"`
+	NewPrice    float64            `bson:"newPrice" json:"newPrice"`
+}
+
+// BulkPriceOperation es una actualización masiva de precios (ej. por inflación). Se guarda para poder deshacerla.
+type BulkPriceOperation struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID     primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	Kind        PriceKind           `bson:"kind" json:"kind"`
+	PriceList   string              `bson:"priceList,omitempty" json:"priceList,omitempty"`
+	Mode        string              `bson:"mode" json:"mode"` // "percent" o "fixed"
+	Value       float64             `bson:"value" json:"value"`
+	Rounding    float64             `bson:"rounding,omitempty" json:"rounding,omitempty"`
+	Scope       string              `bson:"scope" json:"scope"` // "all", "type" o "products"
+	ProductType ProductType         `bson:"productType,omitempty" json:"productType,omitempty"`
+	Items       []BulkPriceItem     `bson:"items" json:"items"`
+	UserID      primitive.ObjectID  `bson:"userId" json:"userId"`
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+	UndoneAt    *time.Time          `bson:"undoneAt,omitempty" json:"undoneAt,omitempty"`
+	UndoneBy    *primitive.ObjectID `bson:"undoneBy,omitempty" json:"undoneBy,omitempty"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReceiptStatus string
+
+const (
+	ReceiptDraft     ReceiptStatus = "draft"     // Se puede editar, no toca el stock
+	ReceiptConfirmed ReceiptStatus = "confirmed" // Ya sumó el stock de cada línea
+	ReceiptCancelled ReceiptStatus = "cancelled" // Anulado (si estaba confirmado, se revirtió el stock)
+)
+
+// ReceiptLine es un producto recibido. La cantidad y el costo están en la unidad de la línea
+// (Measurement), que puede ser cualquiera de las unidades configuradas del producto.
+type ReceiptLine struct {
+	ProductID   primitive.ObjectID `bson:"productId" json:"productId"`
+	ProductName string             `bson:"productName" json:"productName"`
+	Quantity    float64            `bson:"quantity" json:"quantity"`
+	Measurement Measurement        `bson:"measurement" json:"measurement"`
+	UnitCost    float64            `bson:"unitCost" json:"unitCost"`
+
+	// Cantidad en la unidad base del producto (la que suma al stock)
+	BaseQuantity float64     `bson:"baseQuantity" json:"baseQuantity"`
+	BaseUnit     Measurement `bson:"baseUnit,omitempty" json:"baseUnit,omitempty"`
+}
+
+// StockQuantity is the quantity that goes into the stock. Receipts created before unit
+// conversions existed have no BaseQuantity and were always in the base unit.
+func (l ReceiptLine) StockQuantity() float64 {
+	if l.BaseQuantity == 0 {
+		return l.Quantity
+	}
+	return l.BaseQuantity
+}
+
+// BaseUnitCost is the cost per base unit of the product.
+func (l ReceiptLine) BaseUnitCost() float64 {
+	quantity := l.StockQuantity()
+	if quantity == 0 {
+		return l.UnitCost
+	}
+	return l.UnitCost * l.Quantity / quantity
+}
+
+// PurchaseReceipt es un remito de compra a un proveedor.
+type PurchaseReceipt struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID     primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	SupplierID  *primitive.ObjectID `bson:"supplierId,omitempty" json:"supplierId,omitempty"`
+	Supplier    string              `bson:"supplier" json:"supplier"` // Nombre (copiado del proveedor si hay SupplierID)
+	Date        time.Time           `bson:"date" json:"date"`
+	Lines       []ReceiptLine       `bson:"lines" json:"lines"`
+	Total       float64             `bson:"total" json:"total"`
+	Notes       string              `bson:"notes,omitempty" json:"notes,omitempty"`
+	Status      ReceiptStatus       `bson:"status" json:"status"`
+	CreatedBy   primitive.ObjectID  `bson:"createdBy" json:"createdBy"`
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time           `bson:"updatedAt" json:"updatedAt"`
+	ConfirmedBy *primitive.ObjectID `bson:"confirmedBy,omitempty" json:"confirmedBy,omitempty"`
+	ConfirmedAt *time.Time          `bson:"confirmedAt,omitempty" json:"confirmedAt,omitempty"`
+	CancelledBy *primitive.ObjectID `bson:"cancelledBy,omitempty" json:"cancelledBy,omitempty"`
+	CancelledAt *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+}
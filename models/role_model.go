@@ -0,0 +1,70 @@
+package models
+
+type Role string
+type Permission string
+
+const (
+	RoleOwner      Role = "owner"      // Dueño: puede todo
+	RoleCashier    Role = "cashier"    // Cajero: vende y carga stock
+	RoleAccountant Role = "accountant" // Contador: solo lectura
+)
+
+const (
+	PermStockRead       Permission = "stock:read"
+	PermStockWrite      Permission = "stock:write"
+	PermSellsRead       Permission = "sells:read"
+	PermSellsWrite      Permission = "sells:write"
+	PermSellsEditClosed Permission = "sells:edit_closed"
+	PermCashRead        Permission = "cash:read"
+	PermCashClose       Permission = "cash:close"
+	PermPaymentsRead    Permission = "payments:read"
+	PermPaymentsSync    Permission = "payments:sync"
+	PermMPLink          Permission = "mercadopago:link"
+
+	PermPricesWrite Permission = "prices:write" // Costos, precios de venta y listas de precios
+
+	PermPurchasesRead   Permission = "purchases:read"
+	PermPurchasesWrite  Permission = "purchases:write"
+	PermPurchasesCancel Permission = "purchases:cancel_confirmed" // Anular un remito ya confirmado (revierte stock)
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleOwner: {
+		PermStockRead, PermStockWrite, PermPricesWrite,
+		PermSellsRead, PermSellsWrite, PermSellsEditClosed,
+		PermCashRead, PermCashClose,
+		PermPaymentsRead, PermPaymentsSync,
+		PermMPLink,
+		PermPurchasesRead, PermPurchasesWrite, PermPurchasesCancel,
+	},
+	RoleCashier: {
+		PermStockRead, PermStockWrite,
+		PermSellsRead, PermSellsWrite,
+		PermCashRead,
+		PermPaymentsRead, PermPaymentsSync,
+		PermPurchasesRead, PermPurchasesWrite,
+	},
+	RoleAccountant: {
+		PermStockRead,
+		PermSellsRead,
+		PermCashRead,
+		PermPaymentsRead,
+		PermPurchasesRead,
+	},
+}
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants the permission.
+func (r Role) Can(p Permission) bool {
+	for _, perm := range rolePermissions[r] {
+		if perm == p {
+			return true
+		}
+	}
+	return false
+}
@@ -24,7 +24,9 @@ type SellHistory struct {
 
 type Sell struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID   primitive.ObjectID `bson:"userId" json:"userId"`
+	StoreID  primitive.ObjectID `bson:"storeId" json:"storeId"`
+	UserID   primitive.ObjectID `bson:"userId" json:"userId"`                     // Titular de la sesión que registró la venta
+	SoldBy   primitive.ObjectID `bson:"soldBy,omitempty" json:"soldBy,omitempty"` // Quién la cobró (el usuario activo en la terminal)
 	Amount   float64            `bson:"amount" json:"amount"`
 	Date     time.Time          `bson:"date" json:"date"` // Creation date
 	Type     SellType           `bson:"type" json:"type"`
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session es una "familia" de refresh tokens: nace en el login y muere en el logout,
+// al expirar o cuando se detecta reutilización de un refresh token ya rotado.
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	RememberMe    bool               `bson:"rememberMe" json:"rememberMe"`
+	Device        string             `bson:"device" json:"device"`
+	IP            string             `bson:"ip" json:"ip"`
+	UserAgent     string             `bson:"userAgent" json:"userAgent"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+	LastSeenAt    time.Time          `bson:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt     time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt     *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedReason string             `bson:"revokedReason,omitempty" json:"revokedReason,omitempty"`
+
+	// En una terminal compartida, el miembro que está operando ahora (cambiado con PIN).
+	// Vacío cuando opera el titular de la sesión.
+	ActingUserID *primitive.ObjectID `bson:"actingUserId,omitempty" json:"actingUserId,omitempty"`
+}
+
+// RefreshToken guarda SOLO el hash del token opaco que recibe el cliente.
+type RefreshToken struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	SessionID  primitive.ObjectID  `bson:"sessionId" json:"sessionId"`
+	UserID     primitive.ObjectID  `bson:"userId" json:"userId"`
+	TokenHash  string              `bson:"tokenHash" json:"-"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time           `bson:"expiresAt" json:"expiresAt"`
+	UsedAt     *time.Time          `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	ReplacedBy *primitive.ObjectID `bson:"replacedBy,omitempty" json:"replacedBy,omitempty"`
+}
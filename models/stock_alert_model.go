@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AlertStatus string
+
+const (
+	AlertOpen         AlertStatus = "open"         // Stock en o por debajo del mínimo
+	AlertAcknowledged AlertStatus = "acknowledged" // Alguien ya la vio, sigue activa
+	AlertResolved     AlertStatus = "resolved"     // El stock volvió a superar el mínimo
+)
+
+// StockAlert avisa que un producto llegó a su stock mínimo. Hay como mucho una alerta activa
+// (open o acknowledged) por producto; se resuelve sola cuando el stock vuelve a subir.
+type StockAlert struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID        primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	ProductID      primitive.ObjectID  `bson:"productId" json:"productId"`
+	ProductName    string              `bson:"productName" json:"productName"`
+	Stock          float64             `bson:"stock" json:"stock"` // Último stock visto mientras la alerta estuvo activa
+	MinStock       float64             `bson:"minStock" json:"minStock"`
+	Unit           Measurement         `bson:"unit" json:"unit"`
+	Status         AlertStatus         `bson:"status" json:"status"`
+	CreatedAt      time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time           `bson:"updatedAt" json:"updatedAt"`
+	AcknowledgedBy *primitive.ObjectID `bson:"acknowledgedBy,omitempty" json:"acknowledgedBy,omitempty"`
+	AcknowledgedAt *time.Time          `bson:"acknowledgedAt,omitempty" json:"acknowledgedAt,omitempty"`
+	ResolvedAt     *time.Time          `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+
+	// true mientras está open o acknowledged: un índice único parcial impide dos activas por producto
+	Active bool `bson:"active,omitempty" json:"-"`
+}
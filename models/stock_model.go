@@ -1,6 +1,10 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type ProductType string
 type Measurement string
@@ -19,12 +23,82 @@ const (
 	Bolsas   Measurement = "BOLSAS"
 )
 
+// Valid reports whether the measurement is one of the known units.
+func (m Measurement) Valid() bool {
+	switch m {
+	case Unidades, Kilos, Cajones, Bolsas:
+		return true
+	}
+	return false
+}
+
+// UnitConversion dice cuántas unidades base (Product.Measurement) hay en una unidad.
+// Ej. tomate en KILOS con {CAJONES, 18}: un cajón son 18 kg.
+type UnitConversion struct {
+	Unit   Measurement `bson:"unit" json:"unit"`
+	Factor float64     `bson:"factor" json:"factor"`
+}
+
 type Product struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	StoreID     primitive.ObjectID `bson:"storeId" json:"storeId"`
 	Name        string             `bson:"name" json:"name"`
 	Stock       float64            `bson:"stock" json:"stock"`
 	Type        ProductType        `bson:"type,omitempty" json:"type,omitempty"`
-	Measurement Measurement        `bson:"measurement" json:"measurement"`
-	Loaded      bool               `bson:"loaded" json:"loaded"`
+	Measurement Measurement        `bson:"measurement" json:"measurement"` // Unidad base: Stock y precios están en esta unidad
+	Loaded      bool               `bson:"loaded" json:"loaded"`           // Ya comprado en el día de compras LoadedOn
+	LoadedOn    string             `bson:"loadedOn,omitempty" json:"-"`    // Día de compras (AAAA-MM-DD, hora de Argentina) del tilde
+
+	// Archivado: no aparece en el stock ni en las listas, pero conserva su historial
+	ArchivedAt *time.Time          `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	ArchivedBy *primitive.ObjectID `bson:"archivedBy,omitempty" json:"archivedBy,omitempty"`
+
+	// Stock mínimo (en la unidad base): al llegar a este valor se abre una alerta. 0 = sin alerta
+	MinStock float64 `bson:"minStock,omitempty" json:"minStock,omitempty"`
+
+	// Otras unidades en las que se compra o vende el producto
+	Conversions []UnitConversion `bson:"conversions,omitempty" json:"conversions,omitempty"`
+	// Unidad en la que se compra (ej. CAJONES); vacía = la unidad base. Se usa en la lista de compras
+	PurchaseUnit Measurement `bson:"purchaseUnit,omitempty" json:"purchaseUnit,omitempty"`
+
+	// Precios por unidad de Measurement. ListPrices guarda el precio de venta de cada
+	// lista de la tienda (Store.PriceLists) por código; sin entrada se usa SalePrice.
+	CostPrice  float64            `bson:"costPrice" json:"costPrice"`
+	SalePrice  float64            `bson:"salePrice" json:"salePrice"`
+	ListPrices map[string]float64 `bson:"listPrices,omitempty" json:"listPrices,omitempty"`
+
+	// Proveedores que traen este producto
+	SupplierIDs []primitive.ObjectID `bson:"supplierIds,omitempty" json:"supplierIds,omitempty"`
+
+	// Costo de la última compra confirmada (por unidad de Measurement)
+	LastUnitCost   float64    `bson:"lastUnitCost,omitempty" json:"lastUnitCost,omitempty"`
+	LastPurchaseAt *time.Time `bson:"lastPurchaseAt,omitempty" json:"lastPurchaseAt,omitempty"`
+}
+
+// UnitFactor returns how many base units there are in one unit (1 for the base unit itself).
+func (p Product) UnitFactor(unit Measurement) (float64, bool) {
+	if unit == "" || unit == p.Measurement {
+		return 1, true
+	}
+	for _, conversion := range p.Conversions {
+		if conversion.Unit == unit {
+			return conversion.Factor, true
+		}
+	}
+	return 0, false
+}
+
+// ToBase converts a quantity expressed in unit to the base unit of the product.
+func (p Product) ToBase(quantity float64, unit Measurement) (float64, bool) {
+	factor, ok := p.UnitFactor(unit)
+	if !ok {
+		return 0, false
+	}
+	return quantity * factor, true
+}
+
+// LoadedOnDay reports whether the product was ticked as bought on the given shopping day.
+// A tick from a previous day no longer counts, so a new day starts with nothing loaded.
+func (p Product) LoadedOnDay(day string) bool {
+	return p.Loaded && p.LoadedOn == day
 }
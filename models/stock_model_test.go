@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestProductToBase(t *testing.T) {
+	tomate := Product{
+		Measurement: Kilos,
+		Conversions: []UnitConversion{{Unit: Cajones, Factor: 18}, {Unit: Bolsas, Factor: 2.5}},
+	}
+	tests := []struct {
+		quantity float64
+		unit     Measurement
+		want     float64
+		ok       bool
+	}{
+		{3, "", 3, true},
+		{3, Kilos, 3, true},
+		{2, Cajones, 36, true},
+		{0.5, Cajones, 9, true},
+		{4, Bolsas, 10, true},
+		{1, Unidades, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := tomate.ToBase(tt.quantity, tt.unit)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ToBase(%v, %q) = %v, %v; want %v, %v", tt.quantity, tt.unit, got, ok, tt.want, tt.ok)
+		}
+	}
+}
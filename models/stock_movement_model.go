@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MovementType string
+
+const (
+	MovementPurchase   MovementType = "purchase"   // Entrada por compra
+	MovementSale       MovementType = "sale"       // Salida por venta
+	MovementWaste      MovementType = "waste"      // Merma
+	MovementAdjustment MovementType = "adjustment" // Ajuste por conteo
+	MovementTransfer   MovementType = "transfer"   // Traspaso (entrada o salida)
+)
+
+// Valid reports whether the type is one of the known movement types.
+func (t MovementType) Valid() bool {
+	switch t {
+	case MovementPurchase, MovementSale, MovementWaste, MovementAdjustment, MovementTransfer:
+		return true
+	}
+	return false
+}
+
+type WasteReason string
+
+const (
+	WasteRotten   WasteReason = "rotten"   // Se pudrió
+	WasteDamaged  WasteReason = "damaged"  // Golpeado o roto
+	WasteTheft    WasteReason = "theft"    // Robo
+	WasteGiveaway WasteReason = "giveaway" // Regalo (yapa, donación)
+)
+
+// Valid reports whether the reason is one of the known waste reasons.
+func (r WasteReason) Valid() bool {
+	switch r {
+	case WasteRotten, WasteDamaged, WasteTheft, WasteGiveaway:
+		return true
+	}
+	return false
+}
+
+// StockMovement es un registro de solo escritura de un cambio de stock.
+// Product.Stock es la suma de los movimientos del producto.
+type StockMovement struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StoreID    primitive.ObjectID `bson:"storeId" json:"storeId"`
+	ProductID  primitive.ObjectID `bson:"productId" json:"productId"`
+	Type       MovementType       `bson:"type" json:"type"`
+	Quantity   float64            `bson:"quantity" json:"quantity"`     // Con signo: positivo entra, negativo sale
+	Unit       Measurement        `bson:"unit" json:"unit"`             // Unidad en la que está expresada la cantidad
+	StockAfter float64            `bson:"stockAfter" json:"stockAfter"` // Stock del producto después del movimiento
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`         // Quién lo registró
+	Reason     string             `bson:"reason,omitempty" json:"reason,omitempty"`
+
+	// Cantidad tal como se cargó, si fue en otra unidad (ej. 2 CAJONES que son 36 KILOS)
+	EnteredQuantity float64     `bson:"enteredQuantity,omitempty" json:"enteredQuantity,omitempty"`
+	EnteredUnit     Measurement `bson:"enteredUnit,omitempty" json:"enteredUnit,omitempty"`
+
+	// Solo mermas: motivo y costo unitario del producto al momento, para valorizar la pérdida
+	WasteReason WasteReason `bson:"wasteReason,omitempty" json:"wasteReason,omitempty"`
+	UnitCost    float64     `bson:"unitCost,omitempty" json:"unitCost,omitempty"`
+
+	// Documento que originó el movimiento (por ejemplo un remito de compra)
+	ReferenceType string              `bson:"referenceType,omitempty" json:"referenceType,omitempty"`
+	ReferenceID   *primitive.ObjectID `bson:"referenceId,omitempty" json:"referenceId,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Store es la verdulería (tenant): dueña del stock, las ventas, los pagos y la cuenta de Mercado Pago.
+// Los usuarios son miembros de una tienda a través de User.StoreID.
+type Store struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	OwnerID   primitive.ObjectID `bson:"ownerId" json:"ownerId"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+
+	// Si está activo, todos los miembros tienen que usar segundo factor para entrar
+	RequireMFA bool `bson:"requireMfa" json:"requireMfa"`
+
+	// Listas de precios adicionales (ej. mayorista para restaurantes). La minorista es Product.SalePrice
+	PriceLists []PriceList `bson:"priceLists,omitempty" json:"priceLists,omitempty"`
+
+	// Usamos un puntero (*MPAccount) para que si no tiene cuenta, sea nil en la DB
+	MPAccount          *MPAccount `bson:"mpAccount,omitempty" json:"mpAccount,omitempty"`
+	MPAccountConnected bool       `bson:"mpAccountConnected" json:"mpAccountConnected"`
+}
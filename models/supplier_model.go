@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Supplier es un proveedor (puestero, mayorista) de la tienda.
+type Supplier struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StoreID   primitive.ObjectID `bson:"storeId" json:"storeId"`
+	Name      string             `bson:"name" json:"name"`
+	CUIT      string             `bson:"cuit,omitempty" json:"cuit,omitempty"` // Solo dígitos
+	Phone     string             `bson:"phone,omitempty" json:"phone,omitempty"`
+	Notes     string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// SupplierPrice es el precio que cobró un proveedor por un producto en un remito confirmado.
+type SupplierPrice struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StoreID     primitive.ObjectID `bson:"storeId" json:"storeId"`
+	SupplierID  primitive.ObjectID `bson:"supplierId" json:"supplierId"`
+	ProductID   primitive.ObjectID `bson:"productId" json:"productId"`
+	ReceiptID   primitive.ObjectID `bson:"receiptId" json:"receiptId"`
+	UnitCost    float64            `bson:"unitCost" json:"unitCost"`
+	Quantity    float64            `bson:"quantity" json:"quantity"`
+	Measurement Measurement        `bson:"measurement" json:"measurement"`
+	Date        time.Time          `bson:"date" json:"date"`
+}
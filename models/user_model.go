@@ -14,10 +14,44 @@ type User struct {
 	Username string `bson:"username" json:"username"`
 	Theme    string `bson:"theme,omitempty" json:"theme,omitempty"`
 	Language string `bson:"language,omitempty" json:"language,omitempty"`
+	Role     Role   `bson:"role,omitempty" json:"role"`
 
-	// Usamos un puntero (*MPAccount) para que si no tiene cuenta, sea nil en la DB
-	MPAccount          *MPAccount `bson:"mpAccount,omitempty" json:"mpAccount,omitempty"`
-	MPAccountConnected bool       `bson:"mpAccountConnected" json:"mpAccountConnected"`
+	// Las cuentas nuevas no pueden iniciar sesión hasta confirmar el email
+	EmailVerified   bool       `bson:"emailVerified" json:"emailVerified"`
+	EmailVerifiedAt *time.Time `bson:"emailVerifiedAt,omitempty" json:"emailVerifiedAt,omitempty"`
+
+	// Tienda a la que pertenece el usuario (el stock, las ventas y Mercado Pago son de la tienda)
+	StoreID primitive.ObjectID `bson:"storeId,omitempty" json:"storeId"`
+
+	// Administración de la plataforma (no confundir con el dueño de una tienda)
+	IsAdmin               bool       `bson:"isAdmin,omitempty" json:"isAdmin,omitempty"`
+	Disabled              bool       `bson:"disabled,omitempty" json:"disabled"`
+	DisabledAt            *time.Time `bson:"disabledAt,omitempty" json:"disabledAt,omitempty"`
+	PasswordResetRequired bool       `bson:"passwordResetRequired,omitempty" json:"passwordResetRequired,omitempty"`
+
+	// PIN numérico para el cambio rápido de usuario en una terminal compartida (solo el hash)
+	PINHash string `bson:"pinHash,omitempty" json:"-"`
+
+	// Segundo factor (TOTP). El secreto nunca se envía al front.
+	MFAEnabled       bool     `bson:"mfaEnabled" json:"mfaEnabled"`
+	MFASecret        string   `bson:"mfaSecret,omitempty" json:"-"`
+	MFAPendingSecret string   `bson:"mfaPendingSecret,omitempty" json:"-"` // Secreto en enrolamiento, todavía sin confirmar
+	MFALastStep      int64    `bson:"mfaLastStep,omitempty" json:"-"`      // Último paso usado, para que un código no sirva dos veces
+	RecoveryCodes    []string `bson:"recoveryCodes,omitempty" json:"-"`    // Hashes de los códigos de recuperación
+}
+
+// Preferencias que acepta el frontend
+var (
+	AllowedThemes    = []string{"light", "dark"}
+	AllowedLanguages = []string{"es", "en"}
+)
+
+// GetRole devuelve el rol del usuario. Los usuarios creados antes de los roles son dueños.
+func (u User) GetRole() Role {
+	if u.Role == "" {
+		return RoleOwner
+	}
+	return u.Role
 }
 
 type MPAccount struct {
@@ -0,0 +1,99 @@
+// Package totp implementa códigos de un solo uso basados en tiempo (RFC 6238),
+// compatibles con Google Authenticator, Authy, etc. (SHA-1, 6 dígitos, 30 segundos).
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 // segundos
+
+	// Pasos de tolerancia hacia atrás y adelante por desfase de reloj
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret encoded in base32.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI to render as a QR code.
+func ProvisioningURI(secret, account, issuer string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step for t.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for the given secret and time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Truncamiento dinámico (RFC 4226, sección 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate checks the code against the secret around time t. It returns the matched
+// step so the caller can reject a code that was already used.
+func Validate(code, secret string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for i := -skew; i <= skew; i++ {
+		step := current + int64(i)
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Clave SHA-1 de los vectores del RFC 6238 (apéndice B): "12345678901234567890" en base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Los vectores del RFC son de 8 dígitos; con 6 quedan los últimos 6
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("Code(%d) = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		ok   bool
+	}{
+		{"current step", "050471", now, true},
+		{"with spaces", " 050 471 ", now, true},
+		{"previous step within skew", "050471", now.Add(Period * time.Second), true},
+		{"outside skew", "050471", now.Add(2 * Period * time.Second), false},
+		{"wrong code", "123456", now, false},
+		{"too short", "05047", now, false},
+	}
+	for _, tt := range tests {
+		step, ok := Validate(tt.code, rfcSecret, tt.at)
+		if ok != tt.ok {
+			t.Errorf("%s: Validate = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && step != Step(now) {
+			t.Errorf("%s: step = %d, want %d", tt.name, step, Step(now))
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret should fail")
+	}
+}